@@ -0,0 +1,267 @@
+// Copyright 2015 DLR-SR. All rights reserved.
+// Use of this source code is governed by the
+// Creative Commons Attribution-NonCommercial-ShareAlike 4.0 International License
+// (http://creativecommons.org/licenses/by-nc-sa/4.0/).
+
+// Check of external links (opt-in subcommand, never executed when the book is generated):
+//
+//   makeWebBook check-external [-concurrency 8] [-timeout 10s] [-interval 1s] [-maxage 24h] bookDirectory
+//
+// All links <a href="http://..."> and <a href="https://..."> in the section, cover and
+// "table of contents" files are checked with HEAD requests (GET, if HEAD is not supported or
+// gives status 403 or 404). Requests to the same host are separated by at least "interval".
+// Broken and redirected links are reported. The results are cached in resources/externalLinks.json
+// and are reused for "maxage", so that repeated runs are fast (broken links, e.g. timeouts or
+// status 503, are not cached).
+
+package main
+
+import (
+   "encoding/json"
+   "flag"
+   "fmt"
+   "github.com/PuerkitoBio/goquery"
+   "io/ioutil"
+   "log"
+   "net/http"
+   "net/url"
+   "os"
+   "path/filepath"
+   "sort"
+   "strings"
+   "sync"
+   "time"
+)
+
+// Result of the check of one external link (stored in the cache file)
+type ExternalLinkResult struct {
+   URL          string    `json:"URL"`
+   Status       int       `json:"Status"`       // HTTP status code (0, if the request failed)
+   Error        string    `json:"Error"`        // Error message, if the request failed
+   RedirectedTo string    `json:"RedirectedTo"` // Final URL, if the request was redirected (otherwise "")
+   Checked      time.Time `json:"Checked"`      // Time of the check
+}
+
+// = true, if the link is broken
+func (result ExternalLinkResult) Broken() bool {
+   return result.Error != "" || result.Status >= 400
+}
+
+// Checker of external links
+type ExternalLinkChecker struct {
+   Client      *http.Client                  // Client used for the requests (can be replaced, e.g. for a local test server)
+   Concurrency int                           // Maximum number of concurrent requests
+   Interval    time.Duration                 // Minimum time between two requests to the same host
+   MaxAge      time.Duration                 // Cached results younger than MaxAge are reused (broken links are not cached)
+   Cache       map[string]ExternalLinkResult // Results of previous checks (key: URL)
+
+   mutex    sync.Mutex           // Protects Cache and nextTime
+   nextTime map[string]time.Time // Earliest time of the next request to a host (key: host)
+}
+
+// New checker of external links with default settings; client = nil uses a client with a timeout of 10 s
+func NewExternalLinkChecker(client *http.Client) *ExternalLinkChecker {
+   if client == nil {
+      client = &http.Client{Timeout: 10 * time.Second}
+   }
+   return &ExternalLinkChecker{
+      Client:      client,
+      Concurrency: 8,
+      Interval:    time.Second,
+      MaxAge:      24 * time.Hour,
+      Cache:       make(map[string]ExternalLinkResult),
+      nextTime:    make(map[string]time.Time)}
+}
+
+// Check all urls concurrently and return the results (in the order of urls)
+func (checker *ExternalLinkChecker) Check(urls []string) []ExternalLinkResult {
+   results := make([]ExternalLinkResult, len(urls))
+   indices := make(chan int)
+   var wait sync.WaitGroup
+   concurrency := checker.Concurrency
+   if concurrency < 1 {
+      concurrency = 1
+   }
+   for i := 0; i < concurrency; i++ {
+      wait.Add(1)
+      go func() {
+         defer wait.Done()
+         for index := range indices {
+            results[index] = checker.checkOne(urls[index])
+         }
+      }()
+   }
+   for index := range urls {
+      indices <- index
+   }
+   close(indices)
+   wait.Wait()
+   return results
+}
+
+// Check one url (or use the cached result). Results of broken links (e.g. network errors, timeouts
+// or status 503) are not cached, so that a transient failure is checked again in the next run.
+func (checker *ExternalLinkChecker) checkOne(link string) ExternalLinkResult {
+   checker.mutex.Lock()
+   cached, present := checker.Cache[link]
+   checker.mutex.Unlock()
+   if present && !cached.Broken() && time.Since(cached.Checked) < checker.MaxAge {
+      return cached
+   }
+
+   result := ExternalLinkResult{URL: link}
+   parsedURL, err := url.Parse(link)
+   if err != nil {
+      result.Error = err.Error()
+   } else {
+      checker.waitForHost(parsedURL.Host)
+      response, err := checker.Client.Head(link)
+      if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented ||
+         response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusNotFound) {
+         // HEAD is not supported by the server (some servers answer HEAD with 403 or 404); use GET
+         response.Body.Close()
+         checker.waitForHost(parsedURL.Host)
+         response, err = checker.Client.Get(link)
+      }
+      if err != nil {
+         result.Error = err.Error()
+      } else {
+         response.Body.Close()
+         result.Status = response.StatusCode
+         if response.Request != nil && response.Request.URL.String() != link {
+            result.RedirectedTo = response.Request.URL.String()
+         }
+      }
+   }
+   result.Checked = time.Now()
+
+   checker.mutex.Lock()
+   if !result.Broken() {
+      checker.Cache[link] = result
+   } else {
+      delete(checker.Cache, link)
+   }
+   checker.mutex.Unlock()
+   return result
+}
+
+// Wait until the next request to host is allowed
+func (checker *ExternalLinkChecker) waitForHost(host string) {
+   checker.mutex.Lock()
+   now := time.Now()
+   start := checker.nextTime[host]
+   if start.Before(now) {
+      start = now
+   }
+   checker.nextTime[host] = start.Add(checker.Interval)
+   checker.mutex.Unlock()
+   time.Sleep(start.Sub(now))
+}
+
+// Read the cache of results from fileName (a missing file gives an empty cache)
+func (checker *ExternalLinkChecker) ReadCache(fileName string) {
+   raw, err := ioutil.ReadFile(fileName)
+   if os.IsNotExist(err) {
+      return
+   } else if err != nil {
+      log.Fatal(err)
+   }
+   err = json.Unmarshal(raw, &checker.Cache)
+   if err != nil {
+      fmt.Printf("Warning: Cache file \"%s\" of external links is ignored: %s\n", fileName, err.Error())
+      checker.Cache = make(map[string]ExternalLinkResult)
+   }
+}
+
+// Write the cache of results to fileName
+func (checker *ExternalLinkChecker) WriteCache(fileName string) {
+   raw, err := json.MarshalIndent(checker.Cache, "", "  ")
+   if err != nil {
+      log.Fatal(err)
+   }
+   err = ioutil.WriteFile(fileName, raw, 0644)
+   if err != nil {
+      log.Fatal(err)
+   }
+}
+
+// Subcommand "check-external": check the external links of the book (args are the arguments after the subcommand)
+func checkExternalLinks(args []string) {
+   flags := flag.NewFlagSet("check-external", flag.ExitOnError)
+   concurrency := flags.Int("concurrency", 8, "maximum number of concurrent requests")
+   timeout := flags.Duration("timeout", 10*time.Second, "timeout of one request")
+   interval := flags.Duration("interval", time.Second, "minimum time between two requests to the same host")
+   maxAge := flags.Duration("maxage", 24*time.Hour, "cached results younger than maxage are reused")
+   flags.Parse(args)
+   if flags.NArg() != 1 {
+      fmt.Println("Error: makeWebBook check-external requires exactly one book directory as argument")
+      os.Exit(1)
+   }
+
+   err := os.Chdir(flags.Arg(0))
+   if err != nil {
+      log.Fatal(err)
+   }
+   getConfiguration(filepath.Join("resources", "configuration.json"))
+
+   // Collect external links (key: url, value: files in which the link is present, every file once)
+   files := append([]string{Configuration.CoverFileName, Configuration.TocFileName}, Configuration.SectionsFileNames...)
+   if Configuration.SolutionsFileName != "" {
+      files = append(files, Configuration.SolutionsFileName)
+   }
+   locations := make(map[string][]string)
+   for _, fileName := range files {
+      rawFile, err := ioutil.ReadFile(fileName)
+      if err != nil {
+         continue
+      }
+      doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(rawFile)))
+      if err != nil {
+         log.Fatal(err)
+      }
+      doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+         href := s.AttrOr("href", "")
+         n := len(locations[href])
+         if (strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://")) &&
+            (n == 0 || locations[href][n-1] != fileName) {
+            locations[href] = append(locations[href], fileName)
+         }
+      })
+   }
+   urls := make([]string, 0, len(locations))
+   for link := range locations {
+      urls = append(urls, link)
+   }
+   sort.Strings(urls)
+   fmt.Printf("Check %d external links\n", len(urls))
+
+   // Check links (results of previous runs are reused)
+   checker := NewExternalLinkChecker(&http.Client{Timeout: *timeout})
+   checker.Concurrency = *concurrency
+   checker.Interval = *interval
+   checker.MaxAge = *maxAge
+   cacheFileName := filepath.Join("resources", "externalLinks.json")
+   checker.ReadCache(cacheFileName)
+   results := checker.Check(urls)
+   checker.WriteCache(cacheFileName)
+
+   // Report broken and redirected links
+   nBroken := 0
+   for _, result := range results {
+      where := strings.Join(locations[result.URL], ", ")
+      if result.Broken() {
+         nBroken++
+         if result.Error != "" {
+            fmt.Printf("   Broken link %s (%s) in %s\n", result.URL, result.Error, where)
+         } else {
+            fmt.Printf("   Broken link %s (status %d) in %s\n", result.URL, result.Status, where)
+         }
+      } else if result.RedirectedTo != "" {
+         fmt.Printf("   Redirected link %s -> %s in %s\n", result.URL, result.RedirectedTo, where)
+      }
+   }
+   if nBroken > 0 {
+      fmt.Printf("Error: %d broken external link(s)\n", nBroken)
+      os.Exit(1)
+   }
+}
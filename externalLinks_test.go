@@ -0,0 +1,155 @@
+// Copyright 2015 DLR-SR. All rights reserved.
+// Use of this source code is governed by the
+// Creative Commons Attribution-NonCommercial-ShareAlike 4.0 International License
+// (http://creativecommons.org/licenses/by-nc-sa/4.0/).
+
+package main
+
+import (
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "sync"
+   "testing"
+   "time"
+)
+
+// Local test server that counts the requests (key: method and path)
+type testServer struct {
+   *httptest.Server
+   mutex    sync.Mutex
+   requests map[string]int
+   times    []time.Time // Times of all requests
+}
+
+func newTestServer(handler http.HandlerFunc) *testServer {
+   server := &testServer{requests: make(map[string]int)}
+   server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      server.mutex.Lock()
+      server.requests[r.Method+" "+r.URL.Path]++
+      server.times = append(server.times, time.Now())
+      server.mutex.Unlock()
+      handler(w, r)
+   }))
+   return server
+}
+
+// Checker for the test server without delay between requests
+func newTestChecker(server *testServer) *ExternalLinkChecker {
+   checker := NewExternalLinkChecker(server.Client())
+   checker.Interval = 0
+   return checker
+}
+
+func TestExternalLinkCheckerGetFallback(t *testing.T) {
+   for _, status := range []int{http.StatusMethodNotAllowed, http.StatusNotImplemented, http.StatusForbidden, http.StatusNotFound} {
+      server := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+         if r.Method == http.MethodHead {
+            w.WriteHeader(status)
+         }
+      })
+      result := newTestChecker(server).Check([]string{server.URL + "/page"})[0]
+      if result.Broken() || result.Status != http.StatusOK {
+         t.Errorf("HEAD status %d: result = %+v, want status 200", status, result)
+      }
+      if server.requests["HEAD /page"] != 1 || server.requests["GET /page"] != 1 {
+         t.Errorf("HEAD status %d: requests = %v, want one HEAD and one GET", status, server.requests)
+      }
+      server.Close()
+   }
+}
+
+func TestExternalLinkCheckerBrokenAndRedirected(t *testing.T) {
+   server := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+      switch r.URL.Path {
+      case "/old":
+         http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+      case "/new":
+      default:
+         http.NotFound(w, r)
+      }
+   })
+   defer server.Close()
+   results := newTestChecker(server).Check([]string{server.URL + "/old", server.URL + "/missing"})
+   if results[0].Broken() || results[0].RedirectedTo != server.URL+"/new" {
+      t.Errorf("redirected link: result = %+v, want RedirectedTo = %s", results[0], server.URL+"/new")
+   }
+   if !results[1].Broken() || results[1].Status != http.StatusNotFound {
+      t.Errorf("missing link: result = %+v, want status 404", results[1])
+   }
+   if server.requests["HEAD /missing"] != 1 || server.requests["GET /missing"] != 1 {
+      t.Errorf("missing link: requests = %v, want one HEAD and one GET", server.requests)
+   }
+}
+
+func TestExternalLinkCheckerCache(t *testing.T) {
+   server := newTestServer(func(w http.ResponseWriter, r *http.Request) {})
+   defer server.Close()
+   link := server.URL + "/page"
+
+   // A result younger than MaxAge is reused
+   checker := newTestChecker(server)
+   checker.Check([]string{link})
+   checker.Check([]string{link})
+   if server.requests["HEAD /page"] != 1 {
+      t.Errorf("requests = %d, want 1 (cached result reused)", server.requests["HEAD /page"])
+   }
+
+   // An older result is checked again
+   checker.MaxAge = 0
+   checker.Check([]string{link})
+   if server.requests["HEAD /page"] != 2 {
+      t.Errorf("requests = %d, want 2 (cached result too old)", server.requests["HEAD /page"])
+   }
+
+   // Failed requests are not cached
+   closed := newTestServer(func(w http.ResponseWriter, r *http.Request) {})
+   closedLink := closed.URL + "/page"
+   closed.Close()
+   checker.MaxAge = time.Hour
+   result := checker.Check([]string{closedLink})[0]
+   if result.Error == "" {
+      t.Fatalf("request to closed server: result = %+v, want an error", result)
+   }
+   if _, present := checker.Cache[closedLink]; present {
+      t.Errorf("failed request to %s is cached", closedLink)
+   }
+
+   // Broken links (e.g. a transient status 503) are not cached
+   unavailable := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+      w.WriteHeader(http.StatusServiceUnavailable)
+   })
+   defer unavailable.Close()
+   unavailableLink := unavailable.URL + "/page"
+   checker = newTestChecker(unavailable)
+   checker.Check([]string{unavailableLink})
+   checker.Check([]string{unavailableLink})
+   if _, present := checker.Cache[unavailableLink]; present || unavailable.requests["HEAD /page"] != 2 {
+      t.Errorf("link with status 503: cached = %v, requests = %d, want not cached and 2 requests",
+         present, unavailable.requests["HEAD /page"])
+   }
+}
+
+func TestExternalLinkCheckerInterval(t *testing.T) {
+   server := newTestServer(func(w http.ResponseWriter, r *http.Request) {})
+   defer server.Close()
+   checker := newTestChecker(server)
+   checker.Concurrency = 4
+   checker.Interval = 50 * time.Millisecond
+   urls := make([]string, 4)
+   for i := range urls {
+      urls[i] = server.URL + "/page" + strings.Repeat("x", i)
+   }
+   start := time.Now()
+   checker.Check(urls)
+   if len(server.times) != len(urls) {
+      t.Fatalf("%d requests, want %d", len(server.times), len(urls))
+   }
+   for i, requestTime := range server.times {
+      // The requests are sorted by time; request i is not sent before its time slot start + i*Interval
+      // (the gap between two received requests can be shorter, e.g. if a connection is set up for the first one)
+      if elapsed := requestTime.Sub(start); elapsed < time.Duration(i)*checker.Interval {
+         t.Errorf("request %d received %v after the start, want >= %v", i, elapsed, time.Duration(i)*checker.Interval)
+      }
+   }
+}
@@ -27,8 +27,10 @@ in one directory, such as:
 
 With the command
 
-  makeWebBook bookDirectory
+  makeWebBook [-strict] [-fix-duplicate-ids] [-normalize-ids] bookDirectory
 
+(the external links of a book are checked with
+"makeWebBook check-external bookDirectory", see externalLinks.go)
 the actions described below are performed, provided a corresponding
 <h1> element starts with the text "Chapter" or "Appendix".
 (otherwise the <h1> section is not modified; this is useful for a
@@ -42,12 +44,25 @@ preface or a literature chapter)
         <h2>: 3.2 Array Operators
         <h3>: 3.2.3 Array Multiplication
         <h4>: 3.2.3.5 Matrix Multiplication
+      Appendices are numbered A, B, ..., Z, AA, AB, ...
 
     <caption> elements are updated with a caption number, e.g.
        "Table 3-4: This is a table"
 
     <figcaption> elements are updated with a figcaption number, e.g.
        "Figure 3-7: This is a figure"
+       A <figure> nested in another <figure> is a subfigure: its <figcaption>
+       gets a letter, e.g. "(b) Right part", and can be referenced as
+       "Figure 3-7b", whereas the parent figure keeps one number.
+
+    A <table> without <caption> and an <img> outside of a <figure> are not
+       numbered. With "UncaptionedElements": "warn" in configuration.json, a
+       warning is printed for them; with "UncaptionedElements": "wrap", they
+       are wrapped in caption/figure elements with a placeholder caption:
+           <table><caption>Table 3-5: Caption missing</caption>..</table>
+           <figure><img ..><figcaption>Figure 3-8: Caption missing</figcaption></figure>
+       Images within text (e.g. in <p>) and elements with class="nonumber"
+       are not taken into account.
 
     Equations marked by
            <div class="equation"> $$  ...  $$ </div>
@@ -55,6 +70,45 @@ preface or a literature chapter)
        exactly the string `<div class="equation"` is used with exactly one
        space between "div" and "class"). Example:
            <div class="equation"> $$ (2.1) \;\;\; ax^2 + bx + c = 0$$ </div>
+       If the equation contains an align, eqnarray, gather or flalign
+       environment, every row gets its own number via \tag{..} (with
+       exception of rows marked by \nonumber or \notag and of rows with an
+       own \tag, e.g. \tag{*}). A row with
+       \label{rowID} can be referenced with <a href="#rowID">..</a>.
+       All equations within a <div class="subequations"> container get
+       the same number with a letter, e.g. (2.3a), (2.3b).
+       With "EquationNumberStyle": "tag" in configuration.json, the number
+       of a single line equation is introduced as \tag{2.1} at the end of
+       the equation (MathJax displays it at the right side):
+           <div class="equation"> $$ ax^2 + bx + c = 0 \tag{2.1} $$ </div>
+       A single line equation with an own \tag (e.g. \tag{*}) or with
+       \notag is not numbered and is not counted (numeric tags such as
+       \tag{2.1} are regarded as generated by makeWebBook).
+       A \label{id} in an equation can be used as link target
+       <a href="#id">..</a>. If the <div> has no id, id is used as its id.
+
+  Elements with class="nonumber" (e.g. <h2 class="nonumber">Summary</h2>)
+  are not numbered and are not counted (for an equation, exactly the string
+  `<div class="equation nonumber"` must be used). They get nevertheless an id
+  and can be referenced. Subsections of an unnumbered section are
+  not numbered as well. Elements with class="notoc" are not shown in
+  the "table of contents".
+
+    Footnotes <span class="footnote">Footnote text</span> are replaced by
+       a numbered reference <sup class="footnote-ref" id="fnref_ID">..</sup>
+       and the footnote texts are collected in a numbered list
+       <ol class="footnotes"> at the end of the file (before </body>), or
+       between <!-- BeginFootnotes --> and <!-- EndFootnotes -->, if present.
+       Footnotes are numbered in every file starting at 1. Afterwards, the
+       footnote text must be edited in the list. A footnote can be
+       referenced with <a href="#fn_ID">..</a>. Links in footnote texts
+       are updated as the other links and count as references.
+       With "FootnoteStyle": "sidenote" in configuration.json, footnotes are
+       instead generated as numbered margin notes directly at the reference:
+           <span class="sidenote-ref" id="fnref_ID"><sup>..</sup>
+              <span class="sidenote" id="fn_ID">..Footnote text</span></span>
+       (the position in the margin must be defined by a style sheet).
+       Links in margin notes are updated as the links in footnote texts.
 
   If a number is not present, it is introduced (with exception of <h1>
   element, where a number is only introduced if the text starts with
@@ -62,6 +116,156 @@ preface or a literature chapter)
   If it is present and correct, nothing is changed.
   Otherwise, the number is updated.
 
+  Chapters are numbered starting at 1 and appendices starting at A.
+  This can be changed in configuration.json, for example for a book
+  published in several volumes:
+     "FirstChapterNumber": 7,
+     "FirstAppendixLetter": "D",
+     "Files": {"chapter_10.html": {"ChapterNumber": 12},
+               "appendix_X.html": {"AppendixLetter": "X"}}
+  A file specific number is used for the first <h1> of the file;
+  the following chapters/appendices are numbered from there on.
+  Front matter (an <h1> that starts neither with "Chapter" nor with
+  "Appendix", e.g. a preface) is only numbered, if defined for the file:
+     "Files": {"preface.html": {"FrontMatter": "P"}}
+  The <h1> is not changed, but its sections, captions, equations, etc.
+  are numbered with the prefix, e.g. "P.1", "Figure P-1", "(P.1)".
+  With "FrontMatter": "roman", front matter files are numbered with
+  roman numerals instead, e.g. "ii.1", "Figure ii-1".
+
+- Additional numbered elements (e.g. definitions, theorems, listings)
+  can be defined in configuration.json:
+     "Counters": [{"Name": "definition", "Selector": "div.definition",
+                   "Label": "Definition", "Format": "{chapter}-{n}",
+                   "Reset": "chapter", "InTableOfContents": false}]
+  Exactly the string `<div class="definition"` must be used for such an
+  element. The number (e.g. "Definition 2-3: ") is introduced at the
+  beginning of the element text (after a leading <p> tag). "Format" can
+  contain {chapter}, {section} (number of <h2>) and {n}; "Reset" defines
+  when the counter is reset ("chapter", "section" or "book"; with "book",
+  {chapter} must not be used and the default Format is "{n}").
+  The text between <!-- BeginList definition --> and
+  <!-- EndList definition --> in the "table of contents" file is
+  replaced by a list of all definitions.
+
+- Exercises <div class="exercise"> are numbered per chapter (e.g. "Exercise 3.2: "),
+  if a solutions file is defined in configuration.json:
+     "SolutionsFileName": "solutions.html", "OmitSolutions": false
+  (the numbering can be changed with a counter named "exercise").
+  A <div class="solution"> within or after an exercise is moved into the
+  solutions file and is replaced by a link to the solution
+  <p class="solution-link"><a href="solutions.html#sol_ID">Solution 3.2</a></p>.
+  The solutions file is newly generated on every run, is appended to the
+  book and to the navigation bar, and every solution links back to its
+  exercise. Afterwards, a solution must be edited in the solutions file.
+  With "OmitSolutions": true (student edition), the solutions file is not
+  part of the book and the links to the solutions are empty. The solutions
+  are nevertheless kept in a solutions file outside of the book directory,
+  in "SolutionsDirectory" (default: "../solutions"; links in this file are
+  relative to the book directory). A solutions file of a previous run in the
+  book directory is removed (moved into the backup directory). Without
+  OmitSolutions, these solutions are moved back into the book, if the book
+  directory contains no solutions file.
+
+- Links to elements of the book (<a href="chapter_02.html#sec_ops">2.3</a>)
+  are updated with the actual file name, label and tooltip of the target.
+  A link can be written in short form as [[sec_ops]] or <ref to="sec_ops"/>;
+  it is expanded into a complete link, so the file of the target need not be known.
+  A short form is only expanded, if the id is defined in a section file (or
+  in the inventory of another book), and not within <pre>, <code>, <script>,
+  <style>, html comments and TeX ($$..$$, \(..\), \[..\]).
+  With "ReferenceStyle": "cleveref" in configuration.json, the link text
+  contains the kind of the target, e.g. "Section 2.3", "Figure 3-2",
+  "Equation (2.1)". The style of a single link can be defined with the
+  attribute data-ref="label" (e.g. "2.3"), "Cref" (e.g. "Section 2.3"),
+  "cref" (e.g. "section 2.3") or "number" (e.g. "2.3"). A range of elements
+  is referenced with the attribute data-ref-to="lastID", e.g.
+     <a href="#fig_a" data-ref-to="fig_c">..</a> gives "Figures 3-2 to 3-4"
+  Several elements are referenced with a grouped reference (exactly the
+  string `<span class="refs"` must be used):
+     <span class="refs" data-refs="eq_c eq_a eq_b">..</span>
+  The content of the element is newly generated: the elements are grouped
+  by kind, the labels of a group are sorted in the order of the book, every
+  label is linked to its element and three or more consecutive numbers are
+  compressed to a range, e.g.
+     "Sections 2.3–2.5" or "Equations (3.1), (3.4) and (3.7)"
+  or, with several kinds, "Equations (3.1), (3.4) and Section 2.3"
+  (the attribute data-ref defines the reference style as for a single link).
+
+- File names in configuration.json are relative to the book directory and
+  may contain subdirectories (e.g. "part1/chapter_01.html"). Links are
+  resolved relative to the file in which they are present (e.g.
+  "../part2/chapter_03.html#sec_x" or "./chapter_02.html"); only links with
+  a scheme or host (e.g. "http://..", "mailto:..") or with an absolute path
+  are external. Generated links (navigation bars, "table of contents",
+  updated links) are relative to the file in which they are present.
+
+- With "UnreferencedTargets": "warn" in configuration.json, the numbered
+  figures, tables and equations and the entries of reference lists
+  (<ul class="references"><li id="..">) that are never referenced by a link
+  are listed per file. With "UnreferencedTargets": "error", the program
+  additionally exits with a non-zero exit code and no file is changed.
+  A reference to a subfigure or subequation is a reference to its parent
+  figure or equation (subfigures and subequations are not listed separately).
+
+- With "ReferenceOrder": "warn" in configuration.json, numbered figures
+  and tables are listed that are referenced only after they appear or that
+  are first referenced in another chapter. With "MaxSectionDistance": N,
+  figures and tables placed more than N sections (h1 - h4) away from their
+  first reference are listed too. With "ReferenceOrder": "error", the
+  program additionally exits with a non-zero exit code and no file is changed.
+
+- An id must be unique in its file, and the id of an element that can be
+  referenced from other files (e.g. a section, caption or equation) must
+  be unique in the book. Duplicate ids are errors that are reported with
+  the file and line of both occurrences (no file is changed). With option
+  -fix-duplicate-ids, the later occurrence is renamed (e.g. "sec_x" to
+  "sec_x_2"). If the first occurrence is in another file, the links that
+  clearly meant the later occurrence (<a href="#sec_x"> in the same file
+  and <a href="file#sec_x"> in other files) are changed accordingly.
+
+- An id should consist only of the characters A-Z, a-z, 0-9, "-", "_", "."
+  and ":". Other ids (e.g. with spaces or quotes) are reported with their
+  file and line. In generated links, ids are always escaped
+  (e.g. <a href="#sec%20x">). With option -normalize-ids, every sequence of
+  other characters is replaced by "_" (e.g. "sec x" by "sec_x") and the
+  links to the id are changed accordingly.
+
+- The tooltip (title attribute) of a link is the plain text of the target
+  (e.g. "2.3 Operators" for <h2>2.3 <em>Operators</em></h2>). In
+  configuration.json, "TooltipLength": N shortens tooltips to N characters
+  and "OmitTooltipNumbers": true removes the number (e.g. "Operators").
+
+- The file of every bookmark is stored in resources/bookmarkManifest.json.
+  If a bookmark is moved to another file (e.g. a section from chapter_03.html
+  to chapter_04.html), a script is introduced in the old file between
+     <!-- BeginRedirects -->
+        ...
+     <!-- EndRedirects -->
+  that redirects links to the old location (e.g. chapter_03.html#sec_x)
+  to the new one. If the old file is no longer part of the book, a small
+  redirect file with this name is generated.
+
+- All bookmarks (id, file, label, tooltip and kind) are exported in the
+  versioned inventory resources/bookmarkInventory.json. The inventories of
+  other books can be declared in configuration.json under a prefix:
+     "OtherBooks": {"otherbook": {"Inventory": "../otherBook/resources/bookmarkInventory.json",
+                                  "URL": "../otherBook"}}
+  A link <a href="otherbook:sec_ops">..</a> (or [[otherbook:sec_ops]]) is then
+  updated with the URL, label and tooltip of bookmark "sec_ops" of the other
+  book, e.g. <a href="../otherBook/chapter_02.html#sec_ops" data-xref="otherbook:sec_ops">2.3</a>
+  (the reference is kept in attribute data-xref). "URL" is the directory of
+  the other book relative to the book directory or an absolute URL
+  (e.g. "https://example.org/otherBook"); default: the directory of the
+  inventory without "resources". A prefix must not be used as prefix of ids
+  of the book (e.g. id="otherbook:x").
+
+- All internal links are checked: links to a section file, the cover file,
+  the "table of contents" file or another file of the book directory
+  (e.g. "resources/media/data.pdf"), and links to an id. A broken link is
+  reported. With option -strict, broken links are errors: the program
+  exits with a non-zero exit code and no file is changed.
+
 - A navigation bar is introduced in all files with links to the
   "table of contents" file, the previous, and the next file.
 
@@ -82,37 +286,85 @@ package main
 
 import (
    "encoding/json"
+   "flag"
    "fmt"
    "github.com/PuerkitoBio/goquery"
+   "html"
    "io/ioutil"
    "log"
    "math/rand"
+   "net/url"
    "os"
+   "path"
    "path/filepath"
    "regexp"
+   "sort"
    "strconv"
    "strings"
    "time"
-   // "net/http"
 )
 
 type ConfigurationType struct {
-   BackupDirectory   string   `json:"BackupDirectory"`
-   CoverFileName     string   `json:"CoverFileName"`
-   TocFileName       string   `json:"TableOfContentsFileName"`
-   SectionsFileNames []string `json:"SectionsFileNames"`
+   BackupDirectory     string                                `json:"BackupDirectory"`
+   CoverFileName       string                                `json:"CoverFileName"`
+   TocFileName         string                                `json:"TableOfContentsFileName"`
+   SectionsFileNames   []string                              `json:"SectionsFileNames"`
+   FirstChapterNumber  int                                   `json:"FirstChapterNumber"`  // Number of the first chapter (default: 1)
+   FirstAppendixLetter string                                `json:"FirstAppendixLetter"` // Letter(s) of the first appendix (default: "A")
+   Files               map[string]FileConfigurationType      `json:"Files"`               // Optional settings of individual section files (key: file name)
+   EquationNumberStyle string                                `json:"EquationNumberStyle"` // = "text" (default): "$$ (2.1) \;\;\; ..."; = "tag": "$$ ... \tag{2.1} $$"
+   Counters            []CounterConfigurationType            `json:"Counters"`            // Additional numbered elements (e.g. definitions, theorems)
+   FootnoteStyle       string                                `json:"FootnoteStyle"`       // = "endnote" (default): footnote list at the end of the file; = "sidenote": margin notes
+   SolutionsFileName   string                                `json:"SolutionsFileName"`   // If != "": exercises are numbered and their solutions are collected in this file
+   OmitSolutions       bool                                  `json:"OmitSolutions"`       // = true, if the solutions file is not part of the book and exercises are not linked to solutions (student edition)
+   SolutionsDirectory  string                                `json:"SolutionsDirectory"`  // Directory outside of the book directory, in which the solutions file is kept, if OmitSolutions = true (default: "../solutions")
+   UncaptionedElements string                                `json:"UncaptionedElements"` // Tables without <caption> and images outside <figure>: = "ignore" (default), "warn" or "wrap" (in figure/caption)
+   ReferenceStyle      string                                `json:"ReferenceStyle"`      // Text of links: = "label" (default): e.g. "2.3", "Figure 3-2"; = "cleveref": e.g. "Section 2.3", "Figure 3-2"
+   UnreferencedTargets string                                `json:"UnreferencedTargets"` // Figures, tables, equations and references that are never referenced: = "ignore" (default), "warn" or "error"
+   ReferenceOrder      string                                `json:"ReferenceOrder"`      // Figures and tables referenced only after they appear or far away: = "ignore" (default), "warn" or "error"
+   MaxSectionDistance  int                                   `json:"MaxSectionDistance"`  // Maximum number of sections between a figure or table and its first reference (0: not checked)
+   TooltipLength       int                                   `json:"TooltipLength"`       // Maximum number of characters of a tooltip of a link (0: no limit)
+   OmitTooltipNumbers  bool                                  `json:"OmitTooltipNumbers"`  // = true, if the tooltip of a link does not contain the number of the target (e.g. "Two" instead of "2.1 Two")
+   OtherBooks          map[string]OtherBookConfigurationType `json:"OtherBooks"`          // Books that can be referenced with <a href="prefix:id"> (key: prefix)
+}
+
+// Other book that can be referenced in the configuration file, e.g.
+//    "otherbook": {"Inventory": "../otherBook/resources/bookmarkInventory.json", "URL": "../otherBook"}
+type OtherBookConfigurationType struct {
+   Inventory string `json:"Inventory"` // Bookmark inventory of the other book (relative to the book directory)
+   URL       string `json:"URL"`       // Directory of the other book relative to the book directory or absolute URL (default: directory of Inventory without "resources")
+}
+
+// Definition of additional numbered elements in the configuration file, e.g.
+//    {"Name": "definition", "Selector": "div.definition", "Label": "Definition"}
+type CounterConfigurationType struct {
+   Name              string `json:"Name"`              // Name of the counter (used in <!-- BeginList Name --> of the "table of contents" file)
+   Selector          string `json:"Selector"`          // Numbered elements in the form "element.class", e.g. "div.definition"
+   Label             string `json:"Label"`             // Text in front of the number, e.g. "Definition"
+   Format            string `json:"Format"`            // Format of the number with {chapter}, {section}, {n} (default: "{chapter}-{n}"; "{n}", if Reset = "book")
+   Reset             string `json:"Reset"`             // = "chapter" (default): counter is reset at every <h1>; = "section": at every <h2>; = "book": never
+   InTableOfContents bool   `json:"InTableOfContents"` // = true, if the elements are shown in the "table of contents"
+}
+
+// Settings of one section file in the configuration file
+type FileConfigurationType struct {
+   ChapterNumber  int    `json:"ChapterNumber"`  // If > 0: Number of the chapter in this file (following chapters are numbered from here)
+   AppendixLetter string `json:"AppendixLetter"` // If != "": Letter(s) of the appendix in this file (following appendices are numbered from here)
+   FrontMatter    string `json:"FrontMatter"`    // If != "": <h1> that is no chapter/appendix is numbered with this prefix (e.g. "P" -> "P.1", "Figure P-1") or with "roman" numerals (e.g. "ii.1")
 }
 
 // Structure of one book section (h1, h2, ...), used to generate the "table of contents"
 type SectionType struct {
-   FileName  string         // File where section is present
-   ID        string         // <hx id=ID>
-   Label     string         // Label of section (e.g. "Chapter 1", "Preface", "References")
-   Text      string         // <hx id=ID>Text</hx>
-   Modified  bool           // = true, if Text was modified (section/caption/equation number); = false, if it was not modified
-   Sections  []SectionType  // subsections in this section
-   Captions  []CaptionType  // captions and figcaptions in this section before any of the subsections
-   Equations []EquationType // equations in this section before any of the subsections
+   FileName   string         // File where section is present
+   ID         string         // <hx id=ID>
+   Label      string         // Label of section (e.g. "Chapter 1", "Preface", "References")
+   Text       string         // <hx id=ID>Text</hx>
+   Modified   bool           // = true, if Text was modified (section/caption/equation number); = false, if it was not modified
+   Sections   []SectionType  // subsections in this section
+   Captions   []CaptionType  // captions and figcaptions in this section before any of the subsections
+   Equations  []EquationType // equations in this section before any of the subsections
+   Unnumbered bool           // = true, if section has no section number (class="nonumber" or parent section without number)
+   NoToc      bool           // = true, if section is not shown in the "table of contents" (class="notoc")
 }
 
 // Table "caption" or figure "figcaption" information
@@ -122,6 +374,7 @@ type CaptionType struct {
    Text       string // <caption id=ID>Text</caption> or <figcaption id=ID>Text</figcaption>
    Modified   bool   // = true, if Text was modified (section/caption number); = false, if it was not modified
    Figcaption bool   // = true, if figcaption, otherwise caption
+   NoToc      bool   // = true, if caption is not shown in the "table of contents" (class="notoc")
 }
 
 // Equation information
@@ -132,6 +385,12 @@ type EquationType struct {
    Modified bool   // = true, if Text was modified; = false, if it was not modified
 }
 
+// Row of a multi-line equation (align, eqnarray, gather environment)
+type EquationRowType struct {
+   ID    string // \label{ID} of the row (or "", if no label is defined)
+   Label string // Equation number of the row, e.g. "(2.3)" or "(2.3b)"
+}
+
 // Information of one found element, used to update the file
 type ElementType struct {
    StartTag string // Start-tag of element, without closing ">" and without attributes (e.g. "<h1")
@@ -143,6 +402,9 @@ type ElementType struct {
    Modified bool   // = true, if Text was modified (e.g. section or caption number)
    ID       string // id attribute of element or targetID if startTag = "<a"
    NewID    bool   // = true, if a new ID was generated, because no ID was present
+   Replace  bool   // = true, if the complete element (from start tag to end tag) is replaced by NewText
+   RefStyle string // If StartTag == "<a": reference style of the link (data-ref attribute, e.g. "Cref"); otherwise "" (dummy)
+   RefTo    string // If StartTag == "<a": id of the last element of a referenced range (data-ref-to attribute) or ""; otherwise "" (dummy)
 }
 
 // Information about the modified data on a file
@@ -154,6 +416,46 @@ type SectionFileType struct {
    H1Index   int      // The information in this file is a subsection of <h1> in BookStructure.SectionFiles[H1Index]
    Modified  bool     // = true, if at least one element in Elements needs to be modified
    Elements  []ElementType
+   Footnotes []FootnoteType // Footnotes of the file (in the order of their numbers)
+   Generated bool           // = true, if the file is completely generated (solutions file)
+   Source    string         // If != "": Content of the file, modified before the structure was determined (e.g. wrapped tables)
+   Redirects string         // Script that redirects links to bookmarks moved to other files (see redirectScript)
+}
+
+// Bookmark manifest of the last run (stored in resources/bookmarkManifest.json)
+type BookmarkManifestType struct {
+   Bookmarks map[string]string            `json:"Bookmarks"` // File of every bookmark (key: id)
+   Redirects map[string]map[string]string `json:"Redirects"` // Bookmarks moved to other files (key: old file name and id; value: actual file name)
+}
+
+// Information about a footnote
+type FootnoteType struct {
+   ID     string // The footnote reference has id="fnref_ID" and the footnote text has id="fn_ID"
+   Number int    // Footnote number (footnotes are numbered in every file starting at 1)
+   Text   string // Footnote text
+}
+
+// Element that should be referenced in the text (numbered figure, table, equation or entry of a reference list)
+type TargetType struct {
+   ID       string   // id of the element
+   FileName string   // File in which the element is present
+   Kind     string   // "Figure", "Table", "Equation" or "Reference"
+   Label    string   // Reference label, such as "Figure 3-2", "(2.1)", "[3]"
+   Aliases  []string // Further ids that reference the element (e.g. rows of a multi-line equation)
+   Parent   string   // Label of the parent figure or equation of a subfigure or subequation (e.g. "Figure 3-2"), otherwise ""
+}
+
+// Location of an id attribute (Line = 0, if the line is not known)
+type IDLocationType struct {
+   FileName string
+   Line     int
+}
+
+// Id that is present twice
+type DuplicateIDType struct {
+   ID     string
+   First  IDLocationType
+   Second IDLocationType
 }
 
 // Information about a bookmark. All bookmarks are collected
@@ -163,9 +465,17 @@ type SectionFileType struct {
 // FileName: "chapter_02.html"
 // Ref     : "2.3.1"
 type BookmarkType struct {
-   FileName string // File name of bookmark
-   Label    string // Reference label, such as "Chapter 2", "2.3", "Figure 3-2"
-   Tooltip  string // Text to be used as tooltip
+   FileName string `json:"FileName"` // File name of bookmark
+   Label    string `json:"Label"`    // Reference label (plain text), such as "Chapter 2", "2.3", "Figure 3-2"
+   Tooltip  string `json:"Tooltip"`  // Text to be used as tooltip
+   Kind     string `json:"Kind"`     // Kind of a numbered element, such as "Chapter", "Section", "Figure", "Equation", "Definition" (or "")
+   Number   string `json:"Number"`   // Number of a numbered element without kind, such as "2", "2.3", "3-2", "(2.1)" (or "")
+}
+
+// Bookmark inventory of a book (stored in resources/bookmarkInventory.json, can be referenced by other books)
+type BookmarkInventoryType struct {
+   Version   int                     `json:"Version"`   // Version of the inventory format (= bookmarkInventoryVersion)
+   Bookmarks map[string]BookmarkType `json:"Bookmarks"` // All bookmarks of the book (key: id)
 }
 
 /*
@@ -191,15 +501,42 @@ type CountersType struct {
    iFigCaption  int
    iCaption     int
    iEquation    int
+   iSubequation int                // Number of equations in the actual <div class="subequations"> container
+   subequations *goquery.Selection // Actual <div class="subequations"> container (or nil)
+   iSubfigure   int                // Number of subfigures in the actual <figure>
+   figure       *goquery.Selection // Actual numbered <figure> (or nil)
    ih1_digit    int
    ih1_letter   int
-   last_h1_type string // = "Chapter" or "Appendix" or ""
+   last_h1_type string // = "Chapter" or "Appendix" or "FrontMatter" or ""
+   frontMatter  string // If last_h1_type = "FrontMatter": prefix of the numbers (e.g. "P" or "ii")
+   ih1_roman    int    // Number of <h1> elements numbered with roman numerals
+   iCounters    []int  // Counters of the elements defined in Configuration.Counters
 }
 
 // Global variable holding the complete structure of the document
 var Configuration ConfigurationType
+var CounterItems = make(map[string][]CaptionType) // Numbered elements of every counter in Configuration.Counters (key: Name)
+var counterRegexps []*regexp.Regexp               // Regular expressions to find the number of every counter in Configuration.Counters
 var BookStructure BookStructureType
 var Bookmarks = make(map[string]BookmarkType)
+var Solutions = make(map[string]string) // Solutions of exercises (key: id of <div class="exercise">)
+var Targets = make([]TargetType, 0, 50)   // Elements that should be referenced (in the order of the book)
+var FileIDs = make(map[string]map[string]bool) // ids defined in html files (key: file name)
+var DefinedIDs = make(map[string]bool)         // ids defined in the section files (shorthand references are only expanded for them)
+var IDLines = make(map[string]map[string]int)  // Line of the first occurrence of every id attribute in the section files (key: file name, id)
+var DuplicateIDs = make([]DuplicateIDType, 0, 5)
+var RenamedIDs = make(map[string]map[string]string) // ids renamed with option -fix-duplicate-ids, to which links are redirected (key: file name, old id)
+var Redirects = make(map[string]map[string]string)  // Bookmarks moved to other files (key: old file name and id; value: actual file name)
+var OtherBookmarks = make(map[string]map[string]BookmarkType) // Bookmarks of the books in Configuration.OtherBooks (key: prefix, id)
+
+// Global variable: = true, if broken internal links are errors (option -strict)
+var StrictLinkCheck bool
+
+// Global variable: = true, if the later occurrence of a duplicate id is renamed (option -fix-duplicate-ids)
+var FixDuplicateIDs bool
+
+// Global variable: = true, if ids with characters that are not recommended are normalized (option -normalize-ids)
+var NormalizeIDs bool
 var ReqNav = make([]string, 0, 10) // Required nav element
 
 // Global variable holding the full path to the actual backup directory
@@ -209,22 +546,43 @@ var BackupPath string
 var Counters CountersType
 
 // Compiled regular expressions as global variables
-var validSection1 = regexp.MustCompile(`^Chapter [1-9][0-9]* `)                                      // e.g. "Chapter 4 "
-var validSection2 = regexp.MustCompile(`^[1-9][0-9]*[.][1-9][0-9]* `)                                // e.g. "4.2 "
-var validSection3 = regexp.MustCompile(`^[1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]* `)                  // e.g. "4.2.3 "
-var validSection4 = regexp.MustCompile(`^[1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]* `)    // e.g. "4.2.3.5 "
-var validSection1_Appendix = regexp.MustCompile(`^Appendix [A-Z] `)                                  // e.g. "Appendix B "
-var validSection2_Appendix = regexp.MustCompile(`^[A-Z][.][1-9][0-9]* `)                             // e.g. "B.2 "
-var validSection3_Appendix = regexp.MustCompile(`^[A-Z][.][1-9][0-9]*[.][1-9][0-9]* `)               // e.g. "B.2.3 "
-var validSection4_Appendix = regexp.MustCompile(`^[A-Z][.][1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]* `) // e.g. "B.2.3.5 "
-var validCaption = regexp.MustCompile(`^Table [1-9][0-9]*[-][1-9][0-9]*: `)                          // e.g. "Table 3-2: "
-var validFigCaption = regexp.MustCompile(`^Figure [1-9][0-9]*[-][1-9][0-9]*: `)                      // e.g. "Figure 3-2: "
-var validCaption_Appendix = regexp.MustCompile(`^Table [A-Z][-][1-9][0-9]*: `)                       // e.g. "Table B-2: "
-var validFigCaption_Appendix = regexp.MustCompile(`^Figure [A-Z][-][1-9][0-9]*: `)                   // e.g. "Figure B-2: "
-var validEquation = regexp.MustCompile(`\s*[$][$]\s*[(][1-9][0-9]*[.][1-9][0-9]*[)]`)                // e.g. "$$ (2.3)"
-var validEquation_Appendix = regexp.MustCompile(`\s*[$][$]\s*[(][A-Z][.][1-9][0-9]*[)]`)             // e.g. "$$ (B.3)"
-var withEquationNumber = regexp.MustCompile(`\s*[$][$]\s*[(]`)                                       // e.g. "$$ ("
-var equationStart = regexp.MustCompile(`\s*[$][$]`)                                                  // e.g. "$$"
+var validSection1 = regexp.MustCompile(`^Chapter [1-9][0-9]* `)                                                             // e.g. "Chapter 4 "
+var validSection2 = regexp.MustCompile(`^[1-9][0-9]*[.][1-9][0-9]* `)                                                       // e.g. "4.2 "
+var validSection3 = regexp.MustCompile(`^[1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]* `)                                         // e.g. "4.2.3 "
+var validSection4 = regexp.MustCompile(`^[1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]* `)                           // e.g. "4.2.3.5 "
+var validSection1_Appendix = regexp.MustCompile(`^Appendix [A-Z]+ `)                                                        // e.g. "Appendix B ", "Appendix AB "
+var validSection2_Appendix = regexp.MustCompile(`^([A-Z]+|[ivxlcdm]+)[.][1-9][0-9]* `)                                      // e.g. "B.2 ", "ii.2 "
+var validSection3_Appendix = regexp.MustCompile(`^([A-Z]+|[ivxlcdm]+)[.][1-9][0-9]*[.][1-9][0-9]* `)                        // e.g. "B.2.3 "
+var validSection4_Appendix = regexp.MustCompile(`^([A-Z]+|[ivxlcdm]+)[.][1-9][0-9]*[.][1-9][0-9]*[.][1-9][0-9]* `)          // e.g. "B.2.3.5 "
+var validCaption = regexp.MustCompile(`^Table [1-9][0-9]*[-][1-9][0-9]*: `)                                                 // e.g. "Table 3-2: "
+var validFigCaption = regexp.MustCompile(`^Figure [1-9][0-9]*[-][1-9][0-9]*: `)                                             // e.g. "Figure 3-2: "
+var validCaption_Appendix = regexp.MustCompile(`^Table ([A-Z]+|[ivxlcdm]+)[-][1-9][0-9]*: `)                                // e.g. "Table B-2: "
+var validFigCaption_Appendix = regexp.MustCompile(`^Figure ([A-Z]+|[ivxlcdm]+)[-][1-9][0-9]*: `)                            // e.g. "Figure B-2: "
+var validSubfigure = regexp.MustCompile(`^[(][a-z]+[)] `)                                                                   // e.g. "(b) "
+var validEquation = regexp.MustCompile(`\s*[$][$]\s*[(][1-9][0-9]*[.][1-9][0-9]*[a-z]*[)]`)                                 // e.g. "$$ (2.3)"
+var validEquation_Appendix = regexp.MustCompile(`\s*[$][$]\s*[(]([A-Z]+|[ivxlcdm]+)[.][1-9][0-9]*[a-z]*[)]`)                // e.g. "$$ (B.3)"
+var withEquationNumber = regexp.MustCompile(`\s*[$][$]\s*[(]`)                                                              // e.g. "$$ ("
+var equationStart = regexp.MustCompile(`\s*[$][$]`)                                                                         // e.g. "$$"
+var equationPrefix = regexp.MustCompile(`^(\s*[$][$])\s*[(]([1-9A-Z][0-9A-Z]*|[ivxlcdm]+)[.][1-9][0-9]*[a-z]*[)](\s*\\;)*`) // e.g. "$$ (2.3) \;\;\;"
+var multiLineEquation = regexp.MustCompile(`\\begin\{(align|eqnarray|gather|flalign)[*]?\}`)                                // e.g. "\begin{align}"
+var generatedEquationTag = regexp.MustCompile(`\s*\\tag\{([1-9A-Z][0-9A-Z]*|[ivxlcdm]+)[.][1-9][0-9]*[a-z]*\}`)             // e.g. " \tag{2.3}"
+var equationTag = regexp.MustCompile(`\s*\\tag[*]?\{[^{}]*\}`)                                                              // e.g. " \tag{2.3}"
+var equationLabel = regexp.MustCompile(`\\label\{([^{}]+)\}`)                                                               // e.g. "\label{eq_ode}"
+var equationNoNumber = regexp.MustCompile(`\\(nonumber|notag)\b`)                                                           // e.g. "\nonumber"
+var counterTextStart = regexp.MustCompile(`^\s*(<p[^>]*>\s*)?`)                                                             // e.g. "<p>"
+var validCounterSelector = regexp.MustCompile(`^[a-z][a-z0-9]*[.][A-Za-z_][-A-Za-z0-9_]*$`)                                 // e.g. "div.definition"
+var equationAnchors = regexp.MustCompile(`<span class="equation-anchor" id="[^"]*"></span>`)                                // generated anchors of equation rows
+var shorthandReference = regexp.MustCompile(`\[\[([A-Za-z_][-A-Za-z0-9_.:]*)\]\]|<ref\s+to="([^"]+)"\s*(/>|>\s*</ref>)`)    // e.g. "[[sec_ops]]"
+var linkAttributes = regexp.MustCompile(`\s+(href|title)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)                                  // e.g. ` href="#sec_ops"`
+var validBookPrefix = regexp.MustCompile(`^[A-Za-z][-A-Za-z0-9+.]*$`)                                                       // e.g. "otherbook"
+var validID = regexp.MustCompile(`^[-A-Za-z0-9_.:]+$`)                                                                      // e.g. "sec_ops", "1238526924"
+var invalidIDCharacters = regexp.MustCompile(`[^-A-Za-z0-9_.:]+`)                                                           // e.g. " " in "sec ops"
+var idAttribute = regexp.MustCompile(`\sid\s*=\s*("([^"]*)"|'([^']*)')`)                                                    // e.g. ` id="sec_ops"`
+var lastNumber = regexp.MustCompile(`^(.*?)([0-9]+)([^0-9]*)$`)                                                             // e.g. "(3.", "4", ")" in "(3.4)"
+var htmlTitle = regexp.MustCompile(`<title>[^<]*</title>`)                                                                  // e.g. "<title>Chapter 1</title>"
+
+// Regions in which shorthand references are not expanded (comments, <pre>, <code>, <script>, <style> and TeX)
+var noShorthandRegion = regexp.MustCompile(`(?is)<!--.*?-->|<pre\b.*?</pre\s*>|<code\b.*?</code\s*>|<script\b.*?</script\s*>|<style\b.*?</style\s*>|[$][$].*?[$][$]|\\\(.*?\\\)|\\\[.*?\\\]`)
 
 // Constants
 const beginTableOfContents = "<!-- BeginTableOfContents -->"
@@ -232,20 +590,52 @@ const endTableOfContents = "<!-- EndTableOfContents -->"
 const beginNavBar = "<nav>"
 const endNavBar = "</nav>"
 const beginBody = "<body>"
+const endBody = "</body>"
+const beginFootnotes = "<!-- BeginFootnotes -->"
+const endFootnotes = "<!-- EndFootnotes -->"
+const beginRedirects = "<!-- BeginRedirects -->"
+const endRedirects = "<!-- EndRedirects -->"
+const bookmarkManifestFileName = "bookmarkManifest.json" // Name of the bookmark manifest in directory resources
+const bookmarkInventoryFileName = "bookmarkInventory.json" // Name of the bookmark inventory in directory resources
+const bookmarkInventoryVersion = 1                          // Version of the format of the bookmark inventory
 const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+const noNumberClass = "nonumber" // Elements with this class are not numbered
+const noTocClass = "notoc"       // Elements with this class are not shown in the "table of contents"
+const exerciseCounter = "exercise" // Name of the counter of <div class="exercise"> elements
+const solutionsID = "solutions"     // id of the <h1> element of the solutions file
+const missingCaption = "Caption missing" // Placeholder text of captions introduced for tables and images
+const groupedReferenceTag = "<span class=\"refs\"" // Start tag of a grouped reference <span class="refs" data-refs="id1 id2 ..">
 const maxDisplayCharacters = 40 // Maximum number of characters to be showed for captions in Table-of-Contents
 
 func main() {
    // One input argument required: Directory in which book files are present
    // Configuration file must be here: "<arg>/resources/configuration.json"
-   nArgs := len(os.Args)
-   if nArgs < 2 {
+   // Subcommand check-external: Check the external links of the book (see externalLinks.go)
+   if len(os.Args) > 1 && os.Args[1] == "check-external" {
+      checkExternalLinks(os.Args[2:])
+      return
+   }
+
+   // Option -strict: Broken internal links are errors (no file is changed)
+   strict := flag.Bool("strict", false, "exit with an error (without changing files), if an internal link is broken")
+
+   // Option -fix-duplicate-ids: The later occurrence of a duplicate id is renamed (otherwise duplicate ids are errors)
+   fixDuplicateIDs := flag.Bool("fix-duplicate-ids", false, "rename the later occurrence of a duplicate id and the links that clearly refer to it")
+
+   // Option -normalize-ids: ids with characters that are not recommended are normalized (otherwise they are reported)
+   normalizeIDs := flag.Bool("normalize-ids", false, "replace characters in ids that are not recommended by \"_\" and change the links accordingly")
+   flag.Parse()
+   StrictLinkCheck = *strict
+   FixDuplicateIDs = *fixDuplicateIDs
+   NormalizeIDs = *normalizeIDs
+   nArgs := flag.NArg()
+   if nArgs < 1 {
       fmt.Println("Error: No directory name given as input argument for makeWebBook.exe")
       os.Exit(1)
-   } else if nArgs > 2 {
+   } else if nArgs > 1 {
       fmt.Println("Error: 2 or more arguments given to makeWebBook.exe, but only one argument is allowed")
    }
-   bookDirectory := os.Args[1]
+   bookDirectory := flag.Arg(0)
 
    // Change directory to the place where the configuration file is present
    err := os.Chdir(bookDirectory)
@@ -263,17 +653,25 @@ func main() {
    fmt.Println("Configuration file:", fullConfigurationFileName)
    getConfiguration(fullConfigurationFileName)
 
-   // Generate and log backup directory
-   BackupPath = makeBackupDirectory(Configuration.BackupDirectory)
-
    // Get document structure (store in global variable BookStructure)
    getDocumentStructure()
 
+   // Check links and references (exits before the backup directory is generated, if an error is found)
+   checkSectionDocuments()
+
+   // Generate and log backup directory
+   BackupPath = makeBackupDirectory(Configuration.BackupDirectory)
+
    // Update section documents (changed section or caption numbers, introducing ids, etc.)
    updateSectionDocuments()
 
+   // Generate solutions file
+   if Configuration.SolutionsFileName != "" {
+      writeSolutionsFile()
+   }
+
    // Generate Table-of-Contents file
-   movedContentsFileName := filepath.Join(BackupPath, BookStructure.TocFileName)
+   movedContentsFileName := backupFileName(BookStructure.TocFileName)
    err = os.Rename(BookStructure.TocFileName, movedContentsFileName)
    if os.IsNotExist(err) {
       // No contents file exists; generate a new one
@@ -284,6 +682,13 @@ func main() {
       // BookStructure file exists and was moved
       writeContentsFile(movedContentsFileName, BookStructure.TocFileName)
    }
+
+   // Generate redirect files for files that are no longer part of the book and store the actual bookmark locations
+   writeRedirectFiles()
+   writeBookmarkManifest()
+
+   // Export the bookmarks, so that they can be referenced by other books
+   writeBookmarkInventory()
 }
 
 // Get actual time as string so that the string can be used as directory name (":" is replaced by "-")
@@ -347,6 +752,200 @@ func getConfiguration(fileName string) {
       fmt.Println("... Error in json configuration file \"", fileName, "\": ", err.Error())
       os.Exit(2)
    }
+
+   // File names are relative to the book directory (e.g. "part1/chapter_01.html") and are stored in a unique form
+   bookFileName := func(name string) string {
+      if name == "" {
+         return ""
+      }
+      cleaned := path.Clean(filepath.ToSlash(name))
+      if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+         fmt.Printf("... Error in json configuration file \"%s\": File name \"%s\" is not inside the book directory\n", fileName, name)
+         os.Exit(2)
+      }
+      return cleaned
+   }
+   Configuration.CoverFileName = bookFileName(Configuration.CoverFileName)
+   Configuration.TocFileName = bookFileName(Configuration.TocFileName)
+   Configuration.SolutionsFileName = bookFileName(Configuration.SolutionsFileName)
+   for i, name := range Configuration.SectionsFileNames {
+      Configuration.SectionsFileNames[i] = bookFileName(name)
+   }
+   files := make(map[string]FileConfigurationType)
+   for name, fileConfiguration := range Configuration.Files {
+      files[bookFileName(name)] = fileConfiguration
+   }
+   Configuration.Files = files
+
+   // Check start values of chapter and appendix numbers
+   if Configuration.FirstChapterNumber < 0 {
+      fmt.Printf("... Error in json configuration file \"%s\": FirstChapterNumber = %d, but must be >= 1 (or 0 for the default 1)\n",
+         fileName, Configuration.FirstChapterNumber)
+      os.Exit(2)
+   }
+   if Configuration.FirstAppendixLetter != "" && appendixNumber(Configuration.FirstAppendixLetter) < 1 {
+      fmt.Printf("... Error in json configuration file \"%s\": FirstAppendixLetter = \"%s\", but must consist of letters A-Z\n",
+         fileName, Configuration.FirstAppendixLetter)
+      os.Exit(2)
+   }
+   if Configuration.EquationNumberStyle != "" && Configuration.EquationNumberStyle != "text" &&
+      Configuration.EquationNumberStyle != "tag" {
+      fmt.Printf("... Error in json configuration file \"%s\": EquationNumberStyle = \"%s\", but must be \"text\" or \"tag\"\n",
+         fileName, Configuration.EquationNumberStyle)
+      os.Exit(2)
+   }
+   if Configuration.UncaptionedElements != "" && Configuration.UncaptionedElements != "ignore" &&
+      Configuration.UncaptionedElements != "warn" && Configuration.UncaptionedElements != "wrap" {
+      fmt.Printf("... Error in json configuration file \"%s\": UncaptionedElements = \"%s\", but must be \"ignore\", \"warn\" or \"wrap\"\n",
+         fileName, Configuration.UncaptionedElements)
+      os.Exit(2)
+   }
+   if Configuration.UnreferencedTargets != "" && Configuration.UnreferencedTargets != "ignore" &&
+      Configuration.UnreferencedTargets != "warn" && Configuration.UnreferencedTargets != "error" {
+      fmt.Printf("... Error in json configuration file \"%s\": UnreferencedTargets = \"%s\", but must be \"ignore\", \"warn\" or \"error\"\n",
+         fileName, Configuration.UnreferencedTargets)
+      os.Exit(2)
+   }
+   if Configuration.ReferenceOrder != "" && Configuration.ReferenceOrder != "ignore" &&
+      Configuration.ReferenceOrder != "warn" && Configuration.ReferenceOrder != "error" {
+      fmt.Printf("... Error in json configuration file \"%s\": ReferenceOrder = \"%s\", but must be \"ignore\", \"warn\" or \"error\"\n",
+         fileName, Configuration.ReferenceOrder)
+      os.Exit(2)
+   }
+   if Configuration.TooltipLength < 0 || Configuration.TooltipLength > 0 && Configuration.TooltipLength < 4 {
+      fmt.Printf("... Error in json configuration file \"%s\": TooltipLength = %d, but must be 0 (no limit) or >= 4\n",
+         fileName, Configuration.TooltipLength)
+      os.Exit(2)
+   }
+   if Configuration.MaxSectionDistance < 0 {
+      fmt.Printf("... Error in json configuration file \"%s\": MaxSectionDistance = %d, but must be >= 0\n",
+         fileName, Configuration.MaxSectionDistance)
+      os.Exit(2)
+   }
+   if Configuration.ReferenceStyle != "" && Configuration.ReferenceStyle != "label" && Configuration.ReferenceStyle != "cleveref" {
+      fmt.Printf("... Error in json configuration file \"%s\": ReferenceStyle = \"%s\", but must be \"label\" or \"cleveref\"\n",
+         fileName, Configuration.ReferenceStyle)
+      os.Exit(2)
+   }
+   if Configuration.FootnoteStyle != "" && Configuration.FootnoteStyle != "endnote" &&
+      Configuration.FootnoteStyle != "sidenote" {
+      fmt.Printf("... Error in json configuration file \"%s\": FootnoteStyle = \"%s\", but must be \"endnote\" or \"sidenote\"\n",
+         fileName, Configuration.FootnoteStyle)
+      os.Exit(2)
+   }
+   if Configuration.SolutionsDirectory == "" {
+      Configuration.SolutionsDirectory = filepath.Join("..", "solutions")
+   }
+   if Configuration.SolutionsFileName != "" && Configuration.OmitSolutions {
+      // The solutions of the student edition must not be published with the book
+      bookPath, err := os.Getwd()
+      if err != nil {
+         log.Fatal(err)
+      }
+      solutionsPath, err := filepath.Abs(Configuration.SolutionsDirectory)
+      if err != nil {
+         log.Fatal(err)
+      }
+      relativePath, err := filepath.Rel(bookPath, solutionsPath)
+      if err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+         fmt.Printf("... Error in json configuration file \"%s\": SolutionsDirectory = \"%s\" must be outside of the book directory, if OmitSolutions = true\n",
+            fileName, Configuration.SolutionsDirectory)
+         os.Exit(2)
+      }
+   }
+   if Configuration.SolutionsFileName != "" {
+      // The solutions file must not be one of the files of the book
+      for _, file := range append(Configuration.SectionsFileNames, Configuration.CoverFileName, Configuration.TocFileName) {
+         if file == Configuration.SolutionsFileName {
+            fmt.Printf("... Error in json configuration file \"%s\": SolutionsFileName = \"%s\" is already used for another file of the book\n",
+               fileName, Configuration.SolutionsFileName)
+            os.Exit(2)
+         }
+      }
+
+      // Exercises are numbered with a counter (unless a counter with this name is defined in the configuration file)
+      defined := false
+      for _, counter := range Configuration.Counters {
+         if counter.Name == exerciseCounter {
+            defined = true
+         }
+      }
+      if !defined {
+         Configuration.Counters = append(Configuration.Counters,
+            CounterConfigurationType{exerciseCounter, "div.exercise", "Exercise", "{chapter}.{n}", "chapter", false})
+      }
+   }
+   for i, counter := range Configuration.Counters {
+      if counter.Name == "" || counter.Label == "" || !validCounterSelector.MatchString(counter.Selector) {
+         fmt.Printf("... Error in json configuration file \"%s\": Counters[%d] needs a Name, a Label and a Selector of the form \"element.class\"\n",
+            fileName, i)
+         os.Exit(2)
+      }
+      if counter.Reset != "" && counter.Reset != "chapter" && counter.Reset != "section" && counter.Reset != "book" {
+         fmt.Printf("... Error in json configuration file \"%s\": Reset = \"%s\" of counter \"%s\", but must be \"chapter\", \"section\" or \"book\"\n",
+            fileName, counter.Reset, counter.Name)
+         os.Exit(2)
+      }
+      if counter.Format == "" && counter.Reset == "book" {
+         Configuration.Counters[i].Format = "{n}"
+      } else if counter.Format == "" {
+         Configuration.Counters[i].Format = "{chapter}-{n}"
+      }
+      if counter.Reset == "book" && strings.Contains(Configuration.Counters[i].Format, "{chapter}") {
+         // Elements outside of numbered chapters (e.g. in a preface) would get an incomplete number (e.g. ".1")
+         fmt.Printf("... Error in json configuration file \"%s\": Format = \"%s\" of counter \"%s\" must not contain \"{chapter}\", if Reset = \"book\"\n",
+            fileName, Configuration.Counters[i].Format, counter.Name)
+         os.Exit(2)
+      }
+      if !strings.Contains(Configuration.Counters[i].Format, "{n}") {
+         fmt.Printf("... Error in json configuration file \"%s\": Format = \"%s\" of counter \"%s\" does not contain \"{n}\"\n",
+            fileName, counter.Format, counter.Name)
+         os.Exit(2)
+      }
+
+      // Regular expression to find the number, e.g. "Definition 2-3: "
+      pattern := regexp.QuoteMeta(Configuration.Counters[i].Format)
+      pattern = strings.Replace(pattern, `\{chapter\}`, `([1-9][0-9]*|[A-Z]+|[ivxlcdm]+)`, -1)
+      pattern = strings.Replace(pattern, `\{section\}`, `[1-9][0-9]*`, -1)
+      pattern = strings.Replace(pattern, `\{n\}`, `[1-9][0-9]*`, -1)
+      counterRegexps = append(counterRegexps, regexp.MustCompile(`^`+regexp.QuoteMeta(counter.Label)+` `+pattern+`: `))
+   }
+   for prefix, otherBook := range Configuration.OtherBooks {
+      // A prefix must not be confused with the scheme of an external link (e.g. "http:")
+      lowerPrefix := strings.ToLower(prefix)
+      if !validBookPrefix.MatchString(prefix) || lowerPrefix == "http" || lowerPrefix == "https" || lowerPrefix == "mailto" ||
+         lowerPrefix == "ftp" || lowerPrefix == "file" || lowerPrefix == "javascript" || lowerPrefix == "data" || lowerPrefix == "tel" {
+         fmt.Printf("... Error in json configuration file \"%s\": OtherBooks prefix \"%s\" must start with a letter, consist of A-Z, a-z, 0-9, \"-\", \"+\", \".\" and must not be a URL scheme like \"http\"\n",
+            fileName, prefix)
+         os.Exit(2)
+      }
+      if otherBook.Inventory == "" {
+         fmt.Printf("... Error in json configuration file \"%s\": Inventory of OtherBooks \"%s\" is missing\n", fileName, prefix)
+         os.Exit(2)
+      }
+      if otherBook.URL == "" {
+         otherBook.URL = path.Dir(path.Dir(filepath.ToSlash(otherBook.Inventory)))
+         Configuration.OtherBooks[prefix] = otherBook
+      }
+   }
+   for file, fileConfiguration := range Configuration.Files {
+      if fileConfiguration.FrontMatter != "" && fileConfiguration.FrontMatter != "roman" &&
+         appendixNumber(fileConfiguration.FrontMatter) < 1 {
+         fmt.Printf("... Error in json configuration file \"%s\": FrontMatter = \"%s\" of file \"%s\", but must be \"roman\" or consist of letters A-Z\n",
+            fileName, fileConfiguration.FrontMatter, file)
+         os.Exit(2)
+      }
+      if fileConfiguration.ChapterNumber < 0 {
+         fmt.Printf("... Error in json configuration file \"%s\": ChapterNumber = %d of file \"%s\", but must be >= 1 (or 0, if not defined)\n",
+            fileName, fileConfiguration.ChapterNumber, file)
+         os.Exit(2)
+      }
+      if fileConfiguration.AppendixLetter != "" && appendixNumber(fileConfiguration.AppendixLetter) < 1 {
+         fmt.Printf("... Error in json configuration file \"%s\": AppendixLetter = \"%s\" of file \"%s\", but must consist of letters A-Z\n",
+            fileName, fileConfiguration.AppendixLetter, file)
+         os.Exit(2)
+      }
+   }
    return
 }
 
@@ -361,12 +960,43 @@ func getDocumentStructure() {
    // Initialize new random number generator (in order to generator random id's, if no ones are present)
    r := rand.New(rand.NewSource(time.Now().UnixNano()))
 
+   // Initialize counters of the elements defined in the configuration file
+   Counters.iCounters = make([]int, len(Configuration.Counters))
+
+   // Initialize chapter and appendix counters (they are incremented before use)
+   if Configuration.FirstChapterNumber > 0 {
+      Counters.ih1_digit = Configuration.FirstChapterNumber - 1
+   }
+   if Configuration.FirstAppendixLetter != "" {
+      Counters.ih1_letter = appendixNumber(Configuration.FirstAppendixLetter) - 1
+   }
+
    // Determine structure of every section file
    fmt.Println("Determine document structure:")
    H1Index_old := -1
+   readSolutions()
+   readOtherBookInventories()
+   collectDefinedIDs()
    for iFile, file := range Configuration.SectionsFileNames {
       getStructureOfOneFile(file, iFile, r, &H1Index_old)
    }
+   if Configuration.SolutionsFileName != "" && !Configuration.OmitSolutions {
+      addSolutionsFile()
+   }
+
+   // Duplicate ids are errors (no file is changed)
+   if len(DuplicateIDs) > 0 {
+      fmt.Println()
+      for _, duplicate := range DuplicateIDs {
+         fmt.Printf("Error: Duplicate id \"%s\": %s and %s\n", duplicate.ID, duplicate.First, duplicate.Second)
+      }
+      fmt.Printf("Error: %d duplicate id(s) found; no file was changed (use option -fix-duplicate-ids to rename the later occurrences)\n",
+         len(DuplicateIDs))
+      os.Exit(1)
+   }
+
+   // Links to bookmarks that were moved to other files since the last run are redirected
+   getRedirects()
 
    // Build required navigation bar (with exception of Previous and Next)
    ReqNav = append(ReqNav, Configuration.TocFileName)
@@ -387,6 +1017,11 @@ func getDocumentStructure() {
 func checkNavigationBarOfOneFile(iFile int, sectionFile SectionFileType) {
    fileName := BookStructure.SectionFiles[iFile].FileName
 
+   // The nav element of a generated file is always newly generated
+   if sectionFile.Generated {
+      return
+   }
+
    // Check whether a nav element is not present
    if BookStructure.SectionFiles[iFile].NewNav {
       fmt.Printf("   %s (nav will be added)\n", fileName)
@@ -429,7 +1064,7 @@ func checkNavigationBarOfOneFile(iFile int, sectionFile SectionFileType) {
       } else {
          j = i
       }
-      if sectionFile.NavList[i] != ReqNav[j] {
+      if sectionFile.NavList[i] != relativeHref(fileName, ReqNav[j]) {
          BookStructure.SectionFiles[iFile].UpdateNav = true
          fmt.Printf("   %s (nav will be updated)\n", fileName)
          return
@@ -444,26 +1079,104 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
 
    // Store file name and default section/caption structure
    BookStructure.SectionFiles = append(BookStructure.SectionFiles,
-      SectionFileType{fileName, make([]string, 0, 10), true, false, -1, false, make([]ElementType, 0, 10)})
+      SectionFileType{fileName, make([]string, 0, 10), true, false, -1, false, make([]ElementType, 0, 10), make([]FootnoteType, 0, 5), false, "", ""})
    iSectionFile := len(BookStructure.SectionFiles) - 1
 
-   // Open file
-   file, err1 := os.Open(fileName)
-   if err1 != nil {
-      log.Fatal(err1)
+   // Read file
+   rawFile, err := ioutil.ReadFile(fileName)
+   if err != nil {
+      log.Fatal(err)
+   }
+   source := string(rawFile)
+
+   // Shorthand references [[id]] and <ref to="id"/> are expanded into links <a href="#id">id</a>
+   // (file name, label and tooltip of the links are corrected when updating the links)
+   expanded, nExpanded := expandShorthandReferences(source, fileName)
+   if nExpanded > 0 {
+      fmt.Printf("      %d shorthand reference(s) expanded\n", nExpanded)
+      source = expanded
+      BookStructure.SectionFiles[iSectionFile].Source = source
+      BookStructure.SectionFiles[iSectionFile].Modified = true
+   }
+
+   // Duplicate and invalid ids are reported (or renamed with options -fix-duplicate-ids and -normalize-ids)
+   renamed := checkIDs(fileName, source)
+   if renamed != source {
+      source = renamed
+      BookStructure.SectionFiles[iSectionFile].Source = source
+      BookStructure.SectionFiles[iSectionFile].Modified = true
    }
-   defer file.Close()
 
    // Query section structure present in file
-   doc, err := goquery.NewDocumentFromReader(file)
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader(source))
    if err != nil {
       log.Fatal(err)
    }
 
+   // Tables without caption and images without figure are reported or wrapped in figure/caption elements
+   if Configuration.UncaptionedElements == "warn" || Configuration.UncaptionedElements == "wrap" {
+      tables, images := uncaptionedElements(doc)
+      if Configuration.UncaptionedElements == "wrap" && (len(tables) > 0 || len(images) > 0) {
+         wrapped, ok := wrapUncaptionedElements(source, tables, images)
+         if ok {
+            fmt.Printf("      %d table(s) and %d image(s) wrapped in caption/figure elements\n", len(tables), len(images))
+            source = wrapped
+            doc, err = goquery.NewDocumentFromReader(strings.NewReader(source))
+            if err != nil {
+               log.Fatal(err)
+            }
+            BookStructure.SectionFiles[iSectionFile].Source = source
+            BookStructure.SectionFiles[iSectionFile].Modified = true
+            tables, images = nil, nil
+         } else {
+            fmt.Printf("Warning: Tables and images of file %s cannot be wrapped (\"<table\" or \"<img\" present outside of elements)\n", fileName)
+         }
+      }
+      for _, table := range tables {
+         fmt.Printf("Warning: <table> without <caption> in file %s is not numbered: %s\n",
+            fileName, shortenCaption(strings.Join(strings.Fields(doc.Find("table").Eq(table).Text()), " ")))
+      }
+      for _, image := range images {
+         fmt.Printf("Warning: <img src=\"%s\"> outside of <figure> in file %s is not numbered\n",
+            doc.Find("img").Eq(image).AttrOr("src", ""), fileName)
+      }
+   }
+
+   // Footnote texts in the footnote list of the file (key: id of footnote text)
+   footnoteTexts := make(map[string]string)
+   doc.Find("ol.footnotes > li").Each(func(i int, s *goquery.Selection) {
+      id, exists := s.Attr("id")
+      if exists && id != "" {
+         li := s.Clone()
+         li.Find("a.footnote-back").Remove()
+         footnoteText, _ := li.Html()
+         footnoteTexts[id] = strings.TrimSpace(footnoteText)
+      }
+   })
+
    element := false
    iNav := 0
+   firstH1 := true // = true, as long as no <h1> was found in the file
+
+   exerciseID := "" // id of the last <div class="exercise"> in the file
+
+   selector := "h1,h2,h3,h4,caption,figcaption,a,nav,div.equation,ul.references,span.footnote,sup.footnote-ref,span.sidenote-ref,span.refs"
+   for _, counter := range Configuration.Counters {
+      selector = selector + "," + counter.Selector
+   }
+   generated := "span.footnote,sup.footnote-ref,span.sidenote-ref,ol.footnotes,span.refs"
+   if Configuration.SolutionsFileName != "" {
+      selector = selector + ",div.solution,p.solution-link"
+      generated = generated + ",div.solution,p.solution-link"
+   }
+
+   doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+      // Elements in footnotes and solutions are not inspected (they are newly generated or moved;
+      // the links in the footnote texts are updated when checking the links)
+      if s.ParentsFiltered(generated).Length() > 0 {
+         return
+      }
 
-   doc.Find("h1,h2,h3,h4,caption,figcaption,a,nav,div.equation,ul.references").Each(func(i int, s *goquery.Selection) {
       // Inquire whether nav element is present
       if s.Is("nav") {
          // Check that nav is before any other element
@@ -487,6 +1200,106 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
          element = true
       }
 
+      if s.Is("span.footnote") || s.Is("sup.footnote-ref") || s.Is("span.sidenote-ref") { // Footnote detected
+         var id string
+         var footnoteText string
+         if s.Is("span.footnote") {
+            // New footnote: <span class="footnote">footnoteText</span>
+            id = s.AttrOr("id", "")
+            if id == "" || id == "#" {
+               id = strconv.Itoa(int(r.Int31()))
+            }
+            footnoteText, _ = s.Html()
+         } else if s.Is("span.sidenote-ref") {
+            // Existing sidenote: <span class="sidenote-ref" id="fnref_ID">..<span class="sidenote" id="fn_ID">..</span></span>
+            id = strings.TrimPrefix(s.AttrOr("id", ""), "fnref_")
+            sidenote := s.Find("span.sidenote").First().Clone()
+            sidenote.Find("sup.sidenote-number").Remove()
+            footnoteText, _ = sidenote.Html()
+         } else {
+            // Reference to an existing footnote: <sup class="footnote-ref" id="fnref_ID">..</sup>
+            id = strings.TrimPrefix(s.AttrOr("id", ""), "fnref_")
+            var exists bool
+            footnoteText, exists = footnoteTexts["fn_"+id]
+            if !exists {
+               fmt.Printf("Warning: Text of footnote \"fn_%s\" not found in file %s (footnote text is empty)\n", id, fileName)
+            }
+         }
+         footnotes := BookStructure.SectionFiles[iSectionFile].Footnotes
+         footnote := FootnoteType{id, len(footnotes) + 1, strings.TrimSpace(footnoteText)}
+         BookStructure.SectionFiles[iSectionFile].Footnotes = append(footnotes, footnote)
+
+         // The footnote element is replaced by a reference to the footnote text
+         oldText, _ := goquery.OuterHtml(s)
+         newText := footnoteReference(footnote)
+         modified := newText != oldText
+         tagName := goquery.NodeName(s)
+         BookStructure.SectionFiles[iSectionFile].Elements = append(BookStructure.SectionFiles[iSectionFile].Elements,
+            ElementType{"<" + tagName + " class=\"" + s.AttrOr("class", "") + "\"", "</" + tagName + ">",
+               oldText, "", newText, "", modified, id, false, true, "", ""})
+         if modified {
+            BookStructure.SectionFiles[iSectionFile].Modified = true
+            fmt.Printf("      Footnote %d: %s\n", footnote.Number, newText)
+         }
+         addBookmark("fn_"+id, fileName, "Footnote", strconv.Itoa(footnote.Number), shortenCaption(plainText(footnote.Text)))
+         return
+      }
+
+      if s.Is("div.solution") || s.Is("p.solution-link") { // Solution of the last exercise detected
+         if exerciseID == "" {
+            fmt.Printf("Error: <%s class=\"%s\"> present before any <div class=\"exercise\"> in file %s\n",
+               goquery.NodeName(s), s.AttrOr("class", ""), fileName)
+            os.Exit(1)
+         }
+         oldText, _ := goquery.OuterHtml(s)
+         if s.Is("div.solution") {
+            // Move the solution into the solutions file (links to the actual file are adapted)
+            solution := s.Clone()
+            solution.Find("a[href^='#']").Each(func(i int, ss *goquery.Selection) {
+               _, id, _ := linkTarget(fileName, ss.AttrOr("href", ""))
+               ss.SetAttr("href", relativeHref(Configuration.SolutionsFileName, fileName+"#"+id))
+            })
+            solutionText, _ := solution.Html()
+            Solutions[exerciseID] = strings.TrimSpace(solutionText)
+            fmt.Printf("      Solution of exercise \"%s\" moved to %s\n", exerciseID, Configuration.SolutionsFileName)
+         }
+
+         // The solution is replaced by a link to the solution in the solutions file
+         newText := solutionLink(exerciseID, fileName)
+         modified := newText != oldText
+         tagName := goquery.NodeName(s)
+         BookStructure.SectionFiles[iSectionFile].Elements = append(BookStructure.SectionFiles[iSectionFile].Elements,
+            ElementType{"<" + tagName + " class=\"" + s.AttrOr("class", "") + "\"", "</" + tagName + ">",
+               oldText, "", newText, "", modified, exerciseID, false, true, "", ""})
+         if modified {
+            BookStructure.SectionFiles[iSectionFile].Modified = true
+         }
+         return
+      }
+
+      if s.Is("span.refs") { // Grouped reference detected: <span class="refs" data-refs="id1 id2 ..">..</span>
+         refs := s.AttrOr("data-refs", "")
+         if len(strings.Fields(refs)) == 0 {
+            fmt.Printf("Warning: <span class=\"refs\"> without ids in attribute data-refs is ignored in file %s\n", fileName)
+            return
+         }
+         if s.AttrOr("class", "") != "refs" {
+            fmt.Printf("Error: Grouped reference <span class=\"%s\" data-refs=\"%s\"> in file %s must have exactly class=\"refs\"\n",
+               s.AttrOr("class", ""), refs, fileName)
+            os.Exit(1)
+         }
+
+         // The content of the element is generated when checking the links (until then, NewText is the start tag)
+         oldText, _ := goquery.OuterHtml(s)
+         emptyElement := s.Clone()
+         emptyElement.Empty()
+         startTag, _ := goquery.OuterHtml(emptyElement)
+         BookStructure.SectionFiles[iSectionFile].Elements = append(BookStructure.SectionFiles[iSectionFile].Elements,
+            ElementType{groupedReferenceTag, "</span>", oldText, "", strings.TrimSuffix(startTag, "</span>"), "", false, refs, false, true,
+               s.AttrOr("data-ref", ""), ""})
+         return
+      }
+
       if s.Is("a") { // Link detected
          // Check if link is pointing into the book
          if iNav > 0 {
@@ -498,50 +1311,36 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
          if !exists {
             fmt.Printf("Warning: link <a> without href attribute is ignored in file %s\n", fileName)
             BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-               ElementType{"<a", "</a>", "", "", "", "", false, "", false})
+               ElementType{"<a", "</a>", "", "", "", "", false, "", false, false, "", ""})
             return
          }
-         if strings.Index(href, "/") == -1 {
-            // No "/", so link internal to the book
-            var targetFileName string
-            var targetID string
-            tooltip := s.AttrOr("title", "")
-
-            IDstart := strings.Index(href, "#")
-            if IDstart == -1 {
-               // No "#"
-               targetFileName = href
-               targetID = ""
-            } else if IDstart == 0 {
-               // "#xxx", so no file name
-               if len(href) <= 1 {
-                  fmt.Printf("Error: Wrong link '<a href=\"#\">' in file %s\n", fileName)
-                  os.Exit(1)
-               }
-               targetFileName = fileName
-               targetID = href[IDstart+1:]
-            } else {
-               // "xxx#yyy"
-               if IDstart+1 >= len(href) {
-                  targetFileName = href[0:IDstart]
-                  targetID = ""
-               } else {
-                  targetFileName = href[0:IDstart]
-                  targetID = href[IDstart+1:]
-               }
+         xref := s.AttrOr("data-xref", href)
+         if _, _, crossBook := crossBookReference(xref); crossBook {
+            // Link to a bookmark of another book (e.g. <a href="otherbook:sec_ops">); the reference is kept in data-xref
+            BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
+               ElementType{"<a", "</a>", s.Text(), href, "", s.AttrOr("title", ""), false, xref, false, false,
+                  s.AttrOr("data-ref", ""), s.AttrOr("data-ref-to", "")})
+            return
+         }
+         targetFileName, targetID, external := linkTarget(fileName, href)
+         if external {
+            // External link (e.g. "http://..", "mailto:..", "/.."), checked with subcommand check-external
+            BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
+               ElementType{"<a", "</a>", "", "", "", "", false, "", false, false, "", ""})
+         } else if isBookFile(targetFileName) || path.Ext(targetFileName) == ".html" || path.Ext(targetFileName) == ".htm" {
+            // Link to a file of the book or to another html file (e.g. "#sec_ops", "../part2/chapter_02.html#sec_ops")
+            if href == "#" {
+               fmt.Printf("Error: Wrong link '<a href=\"#\">' in file %s\n", fileName)
+               os.Exit(1)
             }
             BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-               ElementType{"<a", "</a>", s.Text(), href, targetFileName, tooltip, false, targetID, false})
+               ElementType{"<a", "</a>", s.Text(), href, targetFileName, s.AttrOr("title", ""), false, targetID, false, false,
+                  s.AttrOr("data-ref", ""), s.AttrOr("data-ref-to", "")})
 
          } else {
+            // Link to another file of the book directory (e.g. "resources/media/data.pdf"); only the existence of the file is checked
             BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-               ElementType{"<a", "</a>", "", "", "", "", false, "", false})
-            /*
-               // External link, check whether it exists
-               _, err := http.Get(href);
-               if err != nil {
-               fmt.Printf("Error when opening link %s\n", href)
-            */
+               ElementType{"<a", "</a>", s.Text(), href, targetFileName, "", false, "", false, false, "", ""})
          }
          return
       }
@@ -563,9 +1362,11 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
                // Store id as bookmark
                title, exists := s2.Attr("title")
                if exists && title != "" {
-                  addBookmark(id, fileName, title, tooltip)
+                  addBookmark(id, fileName, "", title, tooltip)
+                  Targets = append(Targets, TargetType{id, fileName, "Reference", title, nil, ""})
                } else {
-                  addBookmark(id, fileName, "", tooltip)
+                  addBookmark(id, fileName, "", "", tooltip)
+                  Targets = append(Targets, TargetType{id, fileName, "Reference", tooltip, nil, ""})
                }
             }
          })
@@ -577,14 +1378,27 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
       newID := false
       id, exists := s.Attr("id")
       if !exists || id == "" || id == "#" {
-         // If no id present, introduce a random value for id
-         id = strconv.Itoa(int(r.Int31()))
+         // If no id present, use the \label{..} of an equation or introduce a random value for id
+         match := equationLabel.FindStringSubmatch(s.Text())
+         if s.Is("div.equation") && match != nil {
+            id = match[1]
+         } else {
+            id = strconv.Itoa(int(r.Int31()))
+         }
          newID = true
       }
       // text := s.Text()
       text, _ := s.Html()
       modified := false // = true, if text is modified
       var newText string
+      nonumber := s.HasClass(noNumberClass) // = true, if element shall not be numbered
+      var rows []EquationRowType            // rows of a multi-line equation
+      iCounter := counterIndex(s)           // index of Configuration.Counters, if element is numbered by a counter defined in the configuration file
+      var counterTooltip string             // tooltip of an element numbered by a counter defined in the configuration file
+      var captionTooltip string             // tooltip of a subfigure
+      var parentLabel string                // label of the parent figure or equation of a subfigure or subequation
+      var subequationsID string             // id of a <div class="subequations"> container (for its first equation)
+      notoc := s.HasClass(noTocClass)       // = true, if element shall not be shown in the "table of contents"
 
       // Actual index of SectionFiles
       iFile := len(BookStructure.SectionFiles) - 1
@@ -594,23 +1408,46 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
          Counters.iFigCaption = 0
          Counters.iCaption = 0
          Counters.iEquation = 0
+         Counters.subequations = nil
+         Counters.figure = nil
+         resetCounters("chapter")
 
          // Determine chapter number
+         fileConfiguration := Configuration.Files[fileName]
          isec := minInt(len("Chapter"), len(text))
-         if text[0:isec] == "Chapter" {
-            // Increment chapter number
+         if nonumber {
+            // Chapter/appendix shall not be numbered
+            Counters.last_h1_type = ""
+         } else if text[0:isec] == "Chapter" {
+            // Increment chapter number (or use the number defined for the file)
             Counters.ih1_digit++
+            if firstH1 && fileConfiguration.ChapterNumber > 0 {
+               Counters.ih1_digit = fileConfiguration.ChapterNumber
+            }
             Counters.last_h1_type = "Chapter"
          } else {
             isec = minInt(len("Appendix"), len(text))
             if text[0:isec] == "Appendix" {
-               // Increment appendix number
+               // Increment appendix number (or use the letter(s) defined for the file)
                Counters.ih1_letter++
+               if firstH1 && fileConfiguration.AppendixLetter != "" {
+                  Counters.ih1_letter = appendixNumber(fileConfiguration.AppendixLetter)
+               }
                Counters.last_h1_type = "Appendix"
+            } else if fileConfiguration.FrontMatter != "" {
+               // Front matter (e.g. preface): <h1> is not numbered, but its sections, captions, etc.
+               if fileConfiguration.FrontMatter == "roman" {
+                  Counters.ih1_roman++
+                  Counters.frontMatter = romanNumeral(Counters.ih1_roman)
+               } else {
+                  Counters.frontMatter = fileConfiguration.FrontMatter
+               }
+               Counters.last_h1_type = "FrontMatter"
             } else {
                Counters.last_h1_type = ""
             }
          }
+         firstH1 = false
 
          // Update h1 section number if necessary and make a new h1 entry in BookStructure
          newText, modified, label = updateSectionText(text, 1, 0, 0, 0)
@@ -618,9 +1455,10 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             SectionType{fileName, id, label, newText, modified,
                make([]SectionType, 0, 5),
                make([]CaptionType, 0, 5),
-               make([]EquationType, 0, 5)})
+               make([]EquationType, 0, 5),
+               Counters.last_h1_type == "", notoc})
          BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-            ElementType{"<h1", "</h1>", text, "", newText, "", modified, id, newID})
+            ElementType{"<h1", "</h1>", text, "", newText, "", modified, id, newID, false, "", ""})
          BookStructure.SectionFiles[iFile].H1Index = len(BookStructure.Sections) - 1
          *H1Index_old = len(BookStructure.Sections) - 1
 
@@ -630,17 +1468,23 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             fmt.Println("h2 defined before h1 in file:", fileName)
             os.Exit(1)
          }
-         i2 := len(BookStructure.Sections[i1].Sections)
-         newText, modified, label = updateSectionText(text, 2, i2+1, 0, 0)
+         n2 := numberedSections(BookStructure.Sections[i1].Sections)
+         if nonumber {
+            newText, modified, label = text, false, plainText(text)
+         } else {
+            newText, modified, label = updateSectionText(text, 2, n2+1, 0, 0)
+            resetCounters("section")
+         }
          BookStructure.Sections[i1].Sections =
             append(BookStructure.Sections[i1].Sections,
                SectionType{fileName, id, label, newText, modified,
                   make([]SectionType, 0, 5),
                   make([]CaptionType, 0, 5),
-                  make([]EquationType, 0, 5)})
+                  make([]EquationType, 0, 5),
+                  nonumber || BookStructure.Sections[i1].Unnumbered, notoc})
 
          BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-            ElementType{"<h2", "</h2>", text, "", newText, "", modified, id, newID})
+            ElementType{"<h2", "</h2>", text, "", newText, "", modified, id, newID, false, "", ""})
          BookStructure.SectionFiles[iFile].H1Index = *H1Index_old
 
       } else if s.Is("h3") {
@@ -654,16 +1498,23 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             fmt.Println("h3 defined before h2 in file:", fileName)
             os.Exit(1)
          }
-         i3 := len(BookStructure.Sections[i1].Sections[i2].Sections)
-         newText, modified, label = updateSectionText(text, 3, i2+1, i3+1, 0)
+         nonumber = nonumber || BookStructure.Sections[i1].Sections[i2].Unnumbered
+         n2 := numberedSections(BookStructure.Sections[i1].Sections)
+         n3 := numberedSections(BookStructure.Sections[i1].Sections[i2].Sections)
+         if nonumber {
+            newText, modified, label = text, false, plainText(text)
+         } else {
+            newText, modified, label = updateSectionText(text, 3, n2, n3+1, 0)
+         }
          BookStructure.Sections[i1].Sections[i2].Sections =
             append(BookStructure.Sections[i1].Sections[i2].Sections,
                SectionType{fileName, id, label, newText, modified,
                   make([]SectionType, 0, 5),
                   make([]CaptionType, 0, 5),
-                  make([]EquationType, 0, 5)})
+                  make([]EquationType, 0, 5),
+                  nonumber, notoc})
          BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-            ElementType{"<h3", "</h3>", text, "", newText, "", modified, id, newID})
+            ElementType{"<h3", "</h3>", text, "", newText, "", modified, id, newID, false, "", ""})
          BookStructure.SectionFiles[iFile].H1Index = *H1Index_old
 
       } else if s.Is("h4") {
@@ -682,28 +1533,120 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             fmt.Println("h4 defined before h3 in file:", fileName)
             os.Exit(1)
          }
-         i4 := len(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections)
-         newText, modified, label = updateSectionText(text, 4, i2+1, i3+1, i4+1)
+         nonumber = nonumber || BookStructure.Sections[i1].Sections[i2].Sections[i3].Unnumbered
+         n2 := numberedSections(BookStructure.Sections[i1].Sections)
+         n3 := numberedSections(BookStructure.Sections[i1].Sections[i2].Sections)
+         n4 := numberedSections(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections)
+         if nonumber {
+            newText, modified, label = text, false, plainText(text)
+         } else {
+            newText, modified, label = updateSectionText(text, 4, n2, n3, n4+1)
+         }
          BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections =
             append(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections,
                SectionType{fileName, id, label, newText, modified,
                   make([]SectionType, 0, 1),
                   make([]CaptionType, 0, 1),
-                  make([]EquationType, 0, 5)})
+                  make([]EquationType, 0, 5),
+                  nonumber, notoc})
          BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-            ElementType{"<h4", "</h4>", text, "", newText, "", modified, id, newID})
+            ElementType{"<h4", "</h4>", text, "", newText, "", modified, id, newID, false, "", ""})
          BookStructure.SectionFiles[iFile].H1Index = *H1Index_old
 
+      } else if iCounter >= 0 {
+         counter := Configuration.Counters[iCounter]
+         i1 := len(BookStructure.Sections) - 1
+         if i1 < 0 {
+            fmt.Printf("%s in file \"%s\" defined before first h1 defined in book", counter.Selector, fileName)
+            os.Exit(1)
+         }
+         if !nonumber {
+            Counters.iCounters[iCounter]++
+         }
+
+         if nonumber {
+            newText, modified, label = text, false, ""
+         } else {
+            newText, modified, label = updateCounterText(text, iCounter, numberedSections(BookStructure.Sections[i1].Sections))
+         }
+
+         // Store element for lists and for the "table of contents" (plain text, without number and without solution)
+         content := s
+         if counter.Name == exerciseCounter {
+            exerciseID = id
+            content = s.Clone()
+            content.Find("div.solution,p.solution-link").Remove()
+         }
+         plainText := strings.Join(strings.Fields(content.Text()), " ")
+         index := counterRegexps[iCounter].FindStringIndex(plainText)
+         if index != nil {
+            plainText = plainText[index[1]:]
+         }
+         if label != "" {
+            plainText = label + ": " + plainText
+         }
+         counterTooltip = shortenCaption(plainText)
+         item := CaptionType{fileName, id, counterTooltip, modified, false, notoc || !counter.InTableOfContents}
+         CounterItems[counter.Name] = append(CounterItems[counter.Name], item)
+         i2 := len(BookStructure.Sections[i1].Sections) - 1
+         if i2 < 0 {
+            BookStructure.Sections[i1].Captions = append(BookStructure.Sections[i1].Captions, item)
+         } else {
+            i3 := len(BookStructure.Sections[i1].Sections[i2].Sections) - 1
+            if i3 < 0 {
+               BookStructure.Sections[i1].Sections[i2].Captions =
+                  append(BookStructure.Sections[i1].Sections[i2].Captions, item)
+            } else {
+               i4 := len(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections) - 1
+               if i4 < 0 {
+                  BookStructure.Sections[i1].Sections[i2].Sections[i3].Captions =
+                     append(BookStructure.Sections[i1].Sections[i2].Sections[i3].Captions, item)
+               } else {
+                  BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections[i4].Captions =
+                     append(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections[i4].Captions, item)
+               }
+            }
+         }
+
+         tagName := goquery.NodeName(s)
+         BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
+            ElementType{"<" + tagName + " class=\"" + s.AttrOr("class", "") + "\"", "</" + tagName + ">",
+               text, "", newText, "", modified, id, newID, false, "", ""})
+
       } else if s.Is("caption") || s.Is("figcaption") {
          var fig bool
          var iCap int
+         letter := "" // letter of a subfigure
          if s.Is("caption") {
             fig = false
-            Counters.iCaption++
+            if !nonumber {
+               Counters.iCaption++
+            }
             iCap = Counters.iCaption
          } else {
+            // A figure nested in a parent figure is a subfigure: it gets the number of the parent figure with a letter
             fig = true
-            Counters.iFigCaption++
+            figure := s.ParentsFiltered("figure").First()
+            parent := figure.ParentsFiltered("figure").First()
+            if nonumber {
+               // Figure is not numbered
+            } else if parent.Length() == 0 {
+               // The number of a parent figure might already be assigned by its first subfigure
+               if Counters.figure == nil || !figure.IsSelection(Counters.figure) {
+                  Counters.iFigCaption++
+                  Counters.figure = figure
+                  Counters.iSubfigure = 0
+               }
+            } else {
+               if Counters.figure == nil || !parent.IsSelection(Counters.figure) {
+                  // First subfigure of a new parent figure
+                  Counters.iFigCaption++
+                  Counters.figure = parent
+                  Counters.iSubfigure = 0
+               }
+               Counters.iSubfigure++
+               letter = subLetter(Counters.iSubfigure)
+            }
             iCap = Counters.iFigCaption
          }
 
@@ -713,40 +1656,79 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             os.Exit(1)
          }
 
-         newText, modified, label = updateCaptionText(text, fig, iCap)
+         if nonumber {
+            newText, modified, label = text, false, plainText(text)
+         } else {
+            newText, modified, label = updateCaptionText(text, fig, iCap, letter)
+         }
+         if letter != "" && label != plainText(text) {
+            // Subfigures are not shown in the "table of contents" and the tooltip contains the complete figure number
+            notoc = true
+            captionTooltip = label + ": " + newText[len(letter)+3:]
+            parentLabel = strings.TrimSuffix(label, letter)
+         }
          i2 := len(BookStructure.Sections[i1].Sections) - 1
          if i2 < 0 {
             BookStructure.Sections[i1].Captions =
-               append(BookStructure.Sections[i1].Captions, CaptionType{fileName, id, newText, modified, fig})
+               append(BookStructure.Sections[i1].Captions, CaptionType{fileName, id, newText, modified, fig, notoc})
          } else {
             i3 := len(BookStructure.Sections[i1].Sections[i2].Sections) - 1
             if i3 < 0 {
                BookStructure.Sections[i1].Sections[i2].Captions =
                   append(BookStructure.Sections[i1].Sections[i2].Captions,
-                     CaptionType{fileName, id, newText, modified, fig})
+                     CaptionType{fileName, id, newText, modified, fig, notoc})
             } else {
                i4 := len(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections) - 1
                if i4 < 0 {
                   BookStructure.Sections[i1].Sections[i2].Sections[i3].Captions =
                      append(BookStructure.Sections[i1].Sections[i2].Sections[i3].Captions,
-                        CaptionType{fileName, id, newText, modified, fig})
+                        CaptionType{fileName, id, newText, modified, fig, notoc})
                } else {
                   BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections[i4].Captions =
                      append(BookStructure.Sections[i1].Sections[i2].Sections[i3].Sections[i4].Captions,
-                        CaptionType{fileName, id, newText, modified, fig})
+                        CaptionType{fileName, id, newText, modified, fig, notoc})
                }
             }
          }
          if fig {
             BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-               ElementType{"<figcaption", "</figcaption>", text, "", newText, "", modified, id, newID})
+               ElementType{"<figcaption", "</figcaption>", text, "", newText, "", modified, id, newID, false, "", ""})
          } else {
             BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-               ElementType{"<caption", "</caption>", text, "", newText, "", modified, id, newID})
+               ElementType{"<caption", "</caption>", text, "", newText, "", modified, id, newID, false, "", ""})
          }
 
       } else if s.Is("div.equation") {
-         Counters.iEquation++
+         // Equations in a <div class="subequations"> container get the same equation number with a letter
+         letter := ""
+         container := s.ParentsFiltered("div.subequations").First()
+         ownTag := !nonumber && !multiLineEquation.MatchString(text) && ownEquationTag(text)
+         if ownTag {
+            // Equation numbered by the author (e.g. \tag{*}) is not numbered (MathJax rejects a second \tag)
+            nonumber = true
+         }
+         if nonumber {
+            // Equation is not numbered
+         } else if container.Length() == 0 {
+            Counters.subequations = nil
+         } else if Counters.subequations == nil || !container.IsSelection(Counters.subequations) {
+            // First equation in a new container
+            Counters.subequations = container
+            Counters.iSubequation = 0
+            Counters.iEquation++
+            containerID, exists := container.Attr("id")
+            if exists && containerID != "" && containerID != "#" && Counters.last_h1_type != "" {
+               addBookmark(containerID, fileName, "Equation", "("+equationNumber()+")", "")
+               subequationsID = containerID
+            }
+         }
+         if !nonumber && Counters.subequations != nil && !multiLineEquation.MatchString(text) {
+            Counters.iSubequation++
+            letter = subLetter(Counters.iSubequation)
+         }
+         if !nonumber && Counters.subequations != nil && Counters.last_h1_type != "" {
+            parentLabel = "Equation (" + equationNumber() + ")"
+         }
 
          i1 := len(BookStructure.Sections) - 1
          if i1 < 0 {
@@ -754,7 +1736,30 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             os.Exit(1)
          }
 
-         newText, modified, label = updateEquationText(text)
+         // Generated anchors are removed before the equation is updated and are introduced afterwards again
+         tex := equationAnchors.ReplaceAllString(text, "")
+         if ownTag {
+            // A number generated before the own \tag was introduced is removed
+            newText, label = equationPrefix.ReplaceAllString(generatedEquationTag.ReplaceAllString(tex, ""), "$1"), ""
+         } else if nonumber {
+            newText, label = tex, ""
+         } else if multiLineEquation.MatchString(tex) {
+            newText, _, label, rows = updateMultiLineEquationText(tex)
+         } else {
+            if Counters.subequations == nil {
+               Counters.iEquation++
+            }
+            newText, _, label = updateEquationText(tex, letter)
+         }
+         if !multiLineEquation.MatchString(tex) {
+            // A \label{..} of a single line equation can be used to reference the equation
+            match := equationLabel.FindStringSubmatch(tex)
+            if match != nil {
+               rows = []EquationRowType{{html.UnescapeString(match[1]), label}}
+            }
+         }
+         newText = setEquationAnchors(newText, rows, id)
+         modified = newText != text
          i2 := len(BookStructure.Sections[i1].Sections) - 1
          if i2 < 0 {
             BookStructure.Sections[i1].Equations =
@@ -779,7 +1784,7 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             }
          }
          BookStructure.SectionFiles[iFile].Elements = append(BookStructure.SectionFiles[iFile].Elements,
-            ElementType{"<div class=\"equation\"", "</div>", text, "", newText, "", modified, id, newID})
+            ElementType{"<div class=\"" + s.AttrOr("class", "equation") + "\"", "</div>", text, "", newText, "", modified, id, newID, false, "", ""})
       }
 
       if modified || newID {
@@ -794,340 +1799,2272 @@ func getStructureOfOneFile(fileName string, iFile int, r *rand.Rand, H1Index_old
             elem.StartTag, id, newText, elem.EndTag)
       }
 
-      // Store bookmark
+      // Store bookmark (with the kind of a numbered element, e.g. "Section")
+      kind := ""
       if s.Is("div.equation") {
-         addBookmark(id, fileName, label, "") // no tool tip for a link to an equation
+         if label != "" {
+            kind = "Equation"
+         }
+         addBookmark(id, fileName, kind, label, "") // no tool tip for a link to an equation
+
+         // Rows of a multi-line equation (or a single line equation) with a \label{..} can be referenced individually
+         aliases := make([]string, 0, len(rows)+1)
+         if subequationsID != "" {
+            // A link to the container references its subequations
+            aliases = append(aliases, subequationsID)
+         }
+         for _, row := range rows {
+            if row.ID != "" && row.ID != id {
+               addBookmark(row.ID, fileName, kind, row.Label, "")
+               aliases = append(aliases, row.ID)
+            }
+         }
+         if kind != "" {
+            Targets = append(Targets, TargetType{id, fileName, kind, kind + " " + label, aliases, parentLabel})
+         }
+      } else if iCounter >= 0 {
+         if label != "" {
+            kind = Configuration.Counters[iCounter].Label
+         }
+         addBookmark(id, fileName, kind, label, counterTooltip)
       } else {
-         addBookmark(id, fileName, label, newText)
+         // Unnumbered elements have their plain text as label
+         if label != plainText(text) && label != plainText(newText) {
+            if s.Is("h1") {
+               kind = Counters.last_h1_type
+            } else if s.Is("caption") {
+               kind = "Table"
+            } else if s.Is("figcaption") {
+               kind = "Figure"
+            } else {
+               kind = "Section"
+            }
+         }
+         if captionTooltip != "" {
+            addBookmark(id, fileName, kind, label, captionTooltip)
+         } else {
+            addBookmark(id, fileName, kind, label, newText)
+         }
+         if kind == "Table" || kind == "Figure" {
+            Targets = append(Targets, TargetType{id, fileName, kind, label, nil, parentLabel})
+         }
       }
    })
+
+   // Check whether the footnote list needs to be updated
+   footnotes := BookStructure.SectionFiles[iSectionFile].Footnotes
+   iBegin, iEnd := footnoteListPosition(source)
+   if footnoteListNeeded(footnotes, iBegin, iEnd) {
+      if iBegin < 0 {
+         fmt.Printf("Error: File \"%s\" contains footnotes, but neither \"%s\" nor \"%s\"\n", fileName, beginFootnotes, endBody)
+         os.Exit(1)
+      }
+      if source[iBegin:iEnd] != footnoteList(footnotes) {
+         BookStructure.SectionFiles[iSectionFile].Modified = true
+      }
+   }
 }
 
-func addBookmark(id string, fileName string, label string, tooltip string) {
-   key, present := Bookmarks[id]
-   if present {
-      fmt.Printf("ERROR: Bookmark with id = \"%s\" present twice:\n", id)
-      fmt.Printf("       First  location: FileName = \"%s\", Label = \"%s\", Tooltip =\"%s\"\n", key.FileName, key.Label, key.Tooltip)
-      fmt.Printf("       Second location: FileName = \"%s\", Label = \"%s\", Tooltip =\"%s\"\n", fileName, label, tooltip)
-   } else {
-      Bookmarks[id] = BookmarkType{fileName, label, tooltip}
+// Text of an html fragment without markup and with collapsed white space
+func plainText(fragment string) string {
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+   if err != nil {
+      return fragment
    }
+   return strings.Join(strings.Fields(doc.Text()), " ")
 }
 
-// Integer minimum
-func minInt(a, b int) int {
-   if a <= b {
-      return a
+// Reference to a footnote, e.g. <sup class="footnote-ref" id="fnref_ID"><a href="#fn_ID">3</a></sup>.
+// If FootnoteStyle = "sidenote", the reference together with the footnote text as margin note, e.g.
+//    <span class="sidenote-ref" id="fnref_ID"><sup><a href="#fn_ID">3</a></sup><span class="sidenote"
+//          id="fn_ID"><sup class="sidenote-number">3</sup> Text</span></span>
+func footnoteReference(footnote FootnoteType) string {
+   id := html.EscapeString(footnote.ID) // Escaped like goquery does, so that an unchanged reference is not modified
+   if Configuration.FootnoteStyle == "sidenote" {
+      return fmt.Sprintf("<span class=\"sidenote-ref\" id=\"fnref_%s\"><sup><a href=\"#fn_%s\">%d</a></sup>"+
+         "<span class=\"sidenote\" id=\"fn_%s\"><sup class=\"sidenote-number\">%d</sup> %s</span></span>",
+         id, escapeFragment(footnote.ID), footnote.Number, id, footnote.Number, footnote.Text)
+   }
+   return fmt.Sprintf("<sup class=\"footnote-ref\" id=\"fnref_%s\"><a href=\"#fn_%s\">%d</a></sup>",
+      id, escapeFragment(footnote.ID), footnote.Number)
+}
+
+// List of footnotes of a file (including the markers <!-- BeginFootnotes --> and <!-- EndFootnotes -->).
+// If FootnoteStyle = "sidenote", the list is empty.
+func footnoteList(footnotes []FootnoteType) string {
+   str := beginFootnotes + "\n"
+   if len(footnotes) > 0 && Configuration.FootnoteStyle != "sidenote" {
+      str = str + "<ol class=\"footnotes\">\n"
+      for _, footnote := range footnotes {
+         str = str + fmt.Sprintf("<li id=\"fn_%s\">%s <a class=\"footnote-back\" href=\"#fnref_%s\">&#8617;</a></li>\n",
+            html.EscapeString(footnote.ID), footnote.Text, escapeFragment(footnote.ID))
+      }
+      str = str + "</ol>\n"
+   }
+   return str + endFootnotes
+}
+
+// = true, if a footnote list needs to be generated (or updated) at str[iBegin:iEnd] (see footnoteListPosition)
+func footnoteListNeeded(footnotes []FootnoteType, iBegin int, iEnd int) bool {
+   if iBegin < iEnd {
+      // Footnote list present
+      return true
+   }
+   return len(footnotes) > 0 && Configuration.FootnoteStyle != "sidenote"
+}
+
+// Position of the footnote list in str: str[iBegin:iEnd] is the old footnote list
+// (including the markers). If no footnote list is present, iBegin = iEnd = position of </body>.
+// If there is also no </body>, iBegin = iEnd = -1.
+func footnoteListPosition(str string) (iBegin int, iEnd int) {
+   iBegin = strings.Index(str, beginFootnotes)
+   if iBegin >= 0 {
+      iEnd = strings.Index(str[iBegin:], endFootnotes)
+      if iEnd >= 0 {
+         return iBegin, iBegin + iEnd + len(endFootnotes)
+      }
+   }
+   iBegin = strings.LastIndex(str, endBody)
+   return iBegin, iBegin
+}
+
+// Indices of the tables without <caption> in doc.Find("table") and of the images outside of <figure>
+// in doc.Find("img"). Elements with class="nonumber" and images within text (e.g. in <p>) are not taken into account.
+func uncaptionedElements(doc *goquery.Document) (tables []int, images []int) {
+   doc.Find("table").Each(func(i int, s *goquery.Selection) {
+      if s.ChildrenFiltered("caption").Length() == 0 && !s.HasClass(noNumberClass) {
+         tables = append(tables, i)
+      }
+   })
+   doc.Find("img").Each(func(i int, s *goquery.Selection) {
+      if s.ParentsFiltered("figure").Length() == 0 && !s.HasClass(noNumberClass) &&
+         s.Parent().Is("body,div,section,article,main") {
+         images = append(images, i)
+      }
+   })
+   return
+}
+
+// Wrap tables and images in source (indices as returned by uncaptionedElements):
+//    <table ..><caption>Caption missing</caption>..</table>
+//    <figure><img ..><figcaption>Caption missing</figcaption></figure>
+// ok = false, if the start tags in source do not agree with the elements (e.g. "<table" in a comment).
+func wrapUncaptionedElements(source string, tables []int, images []int) (wrapped string, ok bool) {
+   tableTags := indexStartTags(source, "<table")
+   imageTags := indexStartTags(source, "<img")
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader(source))
+   if err != nil || len(tableTags) != doc.Find("table").Length() || len(imageTags) != doc.Find("img").Length() {
+      return source, false
+   }
+
+   // Insertions (position in source -> inserted texts), applied from the end of source.
+   // Several texts can be inserted at the same position (e.g. between two adjacent images);
+   // closing texts are inserted before opening texts.
+   insertions := make(map[int][]string)
+   for _, table := range tables {
+      iTagEnd := strings.Index(source[tableTags[table]:], ">")
+      if iTagEnd < 0 {
+         return source, false
+      }
+      position := tableTags[table] + iTagEnd + 1
+      insertions[position] = append(insertions[position], "<caption>"+missingCaption+"</caption>")
+   }
+   for _, image := range images {
+      iTagEnd := strings.Index(source[imageTags[image]:], ">")
+      if iTagEnd < 0 {
+         return source, false
+      }
+      position := imageTags[image]
+      insertions[position] = append(insertions[position], "<figure>")
+      position = imageTags[image] + iTagEnd + 1
+      insertions[position] = append([]string{"<figcaption>" + missingCaption + "</figcaption></figure>"}, insertions[position]...)
+   }
+   positions := make([]int, 0, len(insertions))
+   for position := range insertions {
+      positions = append(positions, position)
+   }
+   sort.Sort(sort.Reverse(sort.IntSlice(positions)))
+   wrapped = source
+   for _, position := range positions {
+      wrapped = wrapped[0:position] + strings.Join(insertions[position], "") + wrapped[position:]
+   }
+   return wrapped, true
+}
+
+// Positions of all start tags startTag (e.g. "<table") in str
+func indexStartTags(str string, startTag string) []int {
+   positions := make([]int, 0, 10)
+   for i := 0; i < len(str); i++ {
+      if strings.HasPrefix(str[i:], startTag) && i+len(startTag) < len(str) &&
+         strings.ContainsRune(" \t\r\n>/", rune(str[i+len(startTag)])) {
+         positions = append(positions, i)
+      }
+   }
+   return positions
+}
+
+// Link from an exercise to its solution, e.g.
+//    <p class="solution-link"><a href="solutions.html#sol_ID">Solution 3.2</a></p>
+// If the exercise has no solution or OmitSolutions = true, the paragraph is empty.
+// fileName is the file of the exercise.
+func solutionLink(exerciseID string, fileName string) string {
+   _, exists := Solutions[exerciseID]
+   if !exists || Configuration.OmitSolutions {
+      return "<p class=\"solution-link\"></p>"
+   }
+   return fmt.Sprintf("<p class=\"solution-link\"><a href=\"%s\">%s</a></p>",
+      relativeHref(fileName, Configuration.SolutionsFileName+"#sol_"+exerciseID), solutionLabel(exerciseID))
+}
+
+// Label of the solution of an exercise, e.g. "Solution 3.2" for "Exercise 3.2"
+func solutionLabel(exerciseID string) string {
+   fields := strings.Fields(Bookmarks[exerciseID].Label)
+   if len(fields) < 2 {
+      return "Solution"
+   }
+   return "Solution " + fields[len(fields)-1]
+}
+
+// Read the solutions of the exercises from the solutions files (if present). If OmitSolutions = true,
+// the solutions file outside of the book directory is read first and a solutions file in the book
+// directory (of a previous run without OmitSolutions) is read afterwards. Otherwise, the solutions
+// file outside of the book directory (of a previous run with OmitSolutions) is only read, if the book
+// directory contains no solutions file.
+func readSolutions() {
+   if Configuration.SolutionsFileName == "" {
+      return
+   }
+   fileNames := []string{Configuration.SolutionsFileName}
+   storedFileName := filepath.Join(Configuration.SolutionsDirectory, filepath.FromSlash(Configuration.SolutionsFileName))
+   if Configuration.OmitSolutions || !fileExists(Configuration.SolutionsFileName) {
+      fileNames = []string{storedFileName, Configuration.SolutionsFileName}
+   }
+   for _, fileName := range fileNames {
+      rawFile, err := ioutil.ReadFile(fileName)
+      if os.IsNotExist(err) {
+         continue
+      } else if err != nil {
+         log.Fatal(err)
+      }
+      doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(rawFile)))
+      if err != nil {
+         log.Fatal(err)
+      }
+      doc.Find("div.solution").Each(func(i int, s *goquery.Selection) {
+         id := strings.TrimPrefix(s.AttrOr("id", ""), "sol_")
+         if id == "" {
+            return
+         }
+         solution := s.Clone()
+         solution.Find("p.solution-title").Remove()
+         solutionText, _ := solution.Html()
+         Solutions[id] = strings.TrimSpace(solutionText)
+      })
+   }
+}
+
+// Append the solutions file as last file to the book (with an unnumbered <h1>)
+func addSolutionsFile() {
+   fileName := Configuration.SolutionsFileName
+   Configuration.SectionsFileNames = append(Configuration.SectionsFileNames, fileName)
+   BookStructure.Sections = append(BookStructure.Sections,
+      SectionType{fileName, solutionsID, "Solutions", "Solutions", false,
+         make([]SectionType, 0, 1),
+         make([]CaptionType, 0, 1),
+         make([]EquationType, 0, 1),
+         true, false})
+   BookStructure.SectionFiles = append(BookStructure.SectionFiles,
+      SectionFileType{fileName, make([]string, 0, 10), true, false, len(BookStructure.Sections) - 1, false,
+         make([]ElementType, 0, 1), make([]FootnoteType, 0, 1), true, "", ""})
+   addBookmark(solutionsID, fileName, "", "Solutions", "Solutions")
+   for _, exercise := range CounterItems[exerciseCounter] {
+      if _, exists := Solutions[exercise.ID]; exists {
+         addBookmark("sol_"+exercise.ID, fileName, "Solution", solutionLabel(exercise.ID), "")
+      }
+   }
+}
+
+// Determine the bookmarks that were moved to other files since the last run (from the bookmark manifest)
+// and the redirect scripts of the section files
+func getRedirects() {
+   manifest := BookmarkManifestType{}
+   raw, err := ioutil.ReadFile(filepath.Join("resources", bookmarkManifestFileName))
+   if err == nil {
+      err = json.Unmarshal(raw, &manifest)
+      if err != nil {
+         fmt.Printf("Warning: Bookmark manifest \"%s\" is ignored: %s\n", bookmarkManifestFileName, err.Error())
+      }
+   } else if !os.IsNotExist(err) {
+      log.Fatal(err)
+   }
+
+   // Redirects of previous runs are kept (with the actual file name), unless the bookmark was removed or moved back
+   addRedirect := func(oldFileName string, id string) bool {
+      bookmark, present := Bookmarks[id]
+      if !present || bookmark.FileName == oldFileName {
+         return false
+      }
+      if Redirects[oldFileName] == nil {
+         Redirects[oldFileName] = make(map[string]string)
+      }
+      Redirects[oldFileName][id] = bookmark.FileName
+      return true
+   }
+   for oldFileName, ids := range manifest.Redirects {
+      for id := range ids {
+         addRedirect(oldFileName, id)
+      }
+   }
+   moved := make([]string, 0, 5)
+   for id, oldFileName := range manifest.Bookmarks {
+      if addRedirect(oldFileName, id) {
+         moved = append(moved, fmt.Sprintf("   id=\"%s\" moved from %s to %s", id, oldFileName, Bookmarks[id].FileName))
+      }
+   }
+   if len(moved) > 0 {
+      sort.Strings(moved)
+      fmt.Println("\nBookmarks moved to other files (links to the old files are redirected):")
+      for _, line := range moved {
+         fmt.Println(line)
+      }
+   }
+
+   // Section files with changed redirect scripts need to be modified
+   for iSectionFile, sectionFile := range BookStructure.SectionFiles {
+      if sectionFile.Generated {
+         continue
+      }
+      script := redirectScript(sectionFile.FileName)
+      BookStructure.SectionFiles[iSectionFile].Redirects = script
+      source := sectionFile.Source
+      if source == "" {
+         rawFile, err := ioutil.ReadFile(sectionFile.FileName)
+         if err != nil {
+            log.Fatal(err)
+         }
+         source = string(rawFile)
+      }
+      if replaceRedirects(source, script) != source {
+         fmt.Printf("   %s (redirects will be updated)\n", sectionFile.FileName)
+         BookStructure.SectionFiles[iSectionFile].Modified = true
+      }
+   }
+}
+
+// Script that redirects links to bookmarks moved from file fileName to other files (including the markers
+// <!-- BeginRedirects --> and <!-- EndRedirects -->), e.g. chapter_03.html#sec_x to chapter_04.html#sec_x.
+// The script is empty, if no bookmark was moved from the file.
+func redirectScript(fileName string) string {
+   if len(Redirects[fileName]) == 0 {
+      return ""
+   }
+   targets := make(map[string]string)
+   for id, targetFileName := range Redirects[fileName] {
+      targets[id] = relativeHref(fileName, targetFileName)
+   }
+   table, err := json.Marshal(targets) // keys are sorted; "<", ">", "&" are escaped
+   if err != nil {
+      log.Fatal(err)
+   }
+   return beginRedirects + "\n<script>\n" +
+      "// Links to elements that were moved to other files are redirected (generated by makeWebBook)\n" +
+      "(function () {\n" +
+      "   var redirects = " + string(table) + ";\n" +
+      "   var id = decodeURIComponent(window.location.hash.substring(1));\n" +
+      "   if (redirects.hasOwnProperty(id) && !document.getElementById(id)) {\n" +
+      "      window.location.replace(redirects[id] + \"#\" + encodeURIComponent(id));\n" +
+      "   }\n" +
+      "})();\n" +
+      "</script>\n" + endRedirects
+}
+
+// Replace the redirect script in str by script (if no script is present, script is introduced before </body>)
+func replaceRedirects(str string, script string) string {
+   i := strings.Index(str, beginRedirects)
+   if i >= 0 {
+      j := strings.Index(str[i:], endRedirects)
+      if j >= 0 {
+         j = i + j + len(endRedirects)
+         if script == "" && strings.HasPrefix(str[j:], "\n") {
+            j++
+         }
+         return str[0:i] + script + str[j:]
+      }
+   }
+   if script == "" {
+      return str
+   }
+   i = strings.LastIndex(str, endBody)
+   if i < 0 {
+      return str + "\n" + script + "\n"
+   }
+   return str[0:i] + script + "\n" + str[i:]
+}
+
+// Generate a redirect file for every file with moved bookmarks that is no longer part of the book
+// (an existing file is only overwritten, if it is a redirect file)
+func writeRedirectFiles() {
+   fileNames := make([]string, 0, len(Redirects))
+   for fileName := range Redirects {
+      if !isBookFile(fileName) {
+         fileNames = append(fileNames, fileName)
+      }
+   }
+   sort.Strings(fileNames)
+   for _, fileName := range fileNames {
+      content := "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Moved</title>\n</head>\n<body>\n" +
+         "<p>This page was moved. See the <a href=\"" + relativeHref(fileName, Configuration.TocFileName) + "\">Table of Contents</a>.</p>\n" +
+         redirectScript(fileName) + "\n</body>\n</html>\n"
+      rawFile, err := ioutil.ReadFile(filepath.FromSlash(fileName))
+      if err == nil {
+         if string(rawFile) == content {
+            continue
+         } else if !strings.Contains(string(rawFile), beginRedirects) {
+            fmt.Printf("Warning: Redirect file %s not generated, since a file with this name exists\n", fileName)
+            continue
+         }
+         err = os.Rename(filepath.FromSlash(fileName), backupFileName(fileName))
+         if err != nil {
+            log.Fatal(err)
+         }
+      } else if os.IsNotExist(err) {
+         err = os.MkdirAll(filepath.Dir(filepath.FromSlash(fileName)), 0755)
+         if err != nil {
+            log.Fatal(err)
+         }
+      } else {
+         log.Fatal(err)
+      }
+      fmt.Println("Generate redirect file:", fileName)
+      err = ioutil.WriteFile(filepath.FromSlash(fileName), []byte(content), 0644)
+      if err != nil {
+         log.Fatal(err)
+      }
+   }
+}
+
+// Store the actual file of every bookmark and the redirects in the bookmark manifest
+func writeBookmarkManifest() {
+   manifest := BookmarkManifestType{make(map[string]string), Redirects}
+   for id, bookmark := range Bookmarks {
+      manifest.Bookmarks[id] = bookmark.FileName
+   }
+   raw, err := json.MarshalIndent(manifest, "", "  ")
+   if err != nil {
+      log.Fatal(err)
+   }
+   err = ioutil.WriteFile(filepath.Join("resources", bookmarkManifestFileName), raw, 0644)
+   if err != nil {
+      log.Fatal(err)
+   }
+}
+
+// Export all bookmarks in the bookmark inventory, so that they can be referenced by other books
+func writeBookmarkInventory() {
+   inventory := BookmarkInventoryType{bookmarkInventoryVersion, Bookmarks}
+   raw, err := json.MarshalIndent(inventory, "", "  ")
+   if err != nil {
+      log.Fatal(err)
+   }
+   err = ioutil.WriteFile(filepath.Join("resources", bookmarkInventoryFileName), raw, 0644)
+   if err != nil {
+      log.Fatal(err)
+   }
+}
+
+// Expand the shorthand references [[id]] and <ref to="id"/> in source of file fileName into links
+// <a href="#id">id</a> (a reference to another book [[prefix:id]] is expanded into <a href="prefix:id">prefix:id</a>).
+// Shorthand references in tags, comments, <pre>, <code>, <script>, <style> and TeX, and references to ids
+// that are not defined, are not expanded. Returns the expanded source and the number of expanded references.
+func expandShorthandReferences(source string, fileName string) (expanded string, nExpanded int) {
+   excluded := noShorthandRegion.FindAllStringIndex(source, -1)
+   iLast := 0
+   for _, match := range shorthandReference.FindAllStringSubmatchIndex(source, -1) {
+      iBegin, iEnd := match[0], match[1]
+
+      // Skip references in excluded regions and in attributes of tags
+      inExcluded := false
+      for _, region := range excluded {
+         if iBegin >= region[0] && iBegin < region[1] {
+            inExcluded = true
+            break
+         }
+      }
+      if inExcluded || strings.LastIndex(source[0:iBegin], "<") > strings.LastIndex(source[0:iBegin], ">") {
+         continue
+      }
+
+      // Only references to defined ids are expanded
+      var id string
+      if match[2] >= 0 {
+         id = source[match[2]:match[3]]
+      } else {
+         id = html.UnescapeString(source[match[4]:match[5]])
+      }
+      href := "#" + escapeFragment(id)
+      if book, otherID, crossBook := crossBookReference(id); crossBook {
+         if _, present := OtherBookmarks[book][otherID]; !present {
+            fmt.Printf("      Warning: Shorthand reference %s not expanded (id not in the inventory of book \"%s\") in file %s\n",
+               source[iBegin:iEnd], book, fileName)
+            continue
+         }
+         href = html.EscapeString(id)
+      } else if !DefinedIDs[id] {
+         fmt.Printf("      Warning: Shorthand reference %s not expanded (id not defined) in file %s\n", source[iBegin:iEnd], fileName)
+         continue
+      }
+      expanded += source[iLast:iBegin] + "<a href=\"" + href + "\">" + html.EscapeString(id) + "</a>"
+      iLast = iEnd
+      nExpanded++
+   }
+   return expanded + source[iLast:], nExpanded
+}
+
+// Collect the ids defined in the section files: id attributes and \label{id} of equations (that become ids)
+func collectDefinedIDs() {
+   for _, fileName := range Configuration.SectionsFileNames {
+      rawFile, err := ioutil.ReadFile(fileName)
+      if err != nil {
+         continue
+      }
+      source := string(rawFile)
+      for _, match := range idAttribute.FindAllStringSubmatch(source, -1) {
+         DefinedIDs[html.UnescapeString(match[2]+match[3])] = true
+      }
+      for _, match := range equationLabel.FindAllStringSubmatch(source, -1) {
+         DefinedIDs[html.UnescapeString(match[1])] = true
+      }
+   }
+}
+
+// Read the bookmark inventories of the books defined in Configuration.OtherBooks
+func readOtherBookInventories() {
+   for prefix, otherBook := range Configuration.OtherBooks {
+      raw, err := ioutil.ReadFile(otherBook.Inventory)
+      if err != nil {
+         fmt.Printf("Error: Bookmark inventory \"%s\" of other book \"%s\" cannot be read: %s\n", otherBook.Inventory, prefix, err.Error())
+         os.Exit(1)
+      }
+      inventory := BookmarkInventoryType{}
+      err = json.Unmarshal(raw, &inventory)
+      if err != nil {
+         fmt.Printf("Error: Bookmark inventory \"%s\" of other book \"%s\" is not valid: %s\n", otherBook.Inventory, prefix, err.Error())
+         os.Exit(1)
+      }
+      if inventory.Version != bookmarkInventoryVersion {
+         fmt.Printf("Error: Bookmark inventory \"%s\" of other book \"%s\" has version %d, but version %d is required (regenerate the other book)\n",
+            otherBook.Inventory, prefix, inventory.Version, bookmarkInventoryVersion)
+         os.Exit(1)
+      }
+      OtherBookmarks[prefix] = inventory.Bookmarks
+      fmt.Printf("   %d bookmarks of other book \"%s\" (%s)\n", len(inventory.Bookmarks), prefix, otherBook.Inventory)
+   }
+}
+
+// Split a reference to a bookmark of another book "prefix:id" (e.g. "otherbook:sec_ops").
+// crossBook = false, if ref does not start with a prefix of Configuration.OtherBooks.
+func crossBookReference(ref string) (book string, id string, crossBook bool) {
+   i := strings.Index(ref, ":")
+   if i <= 0 {
+      return "", "", false
+   }
+   if _, present := Configuration.OtherBooks[ref[:i]]; !present {
+      return "", "", false
+   }
+   return ref[:i], ref[i+1:], true
+}
+
+// Bookmark with the given id; id = "prefix:id" is a bookmark of another book
+func lookupBookmark(id string) (BookmarkType, bool) {
+   if book, otherID, crossBook := crossBookReference(id); crossBook {
+      bookmark, present := OtherBookmarks[book][otherID]
+      return bookmark, present
+   }
+   bookmark, present := Bookmarks[id]
+   return bookmark, present
+}
+
+// Href of a link in file fileName to bookmark id in file targetFileName of another book,
+// e.g. "../otherBook/chapter_02.html#sec_ops" or "https://example.org/otherBook/chapter_02.html#sec_ops"
+func otherBookHref(fileName string, book string, targetFileName string, id string) string {
+   base := Configuration.OtherBooks[book].URL
+   if parsedURL, err := url.Parse(base); err == nil && (parsedURL.Scheme != "" || parsedURL.Host != "") {
+      return strings.TrimSuffix(base, "/") + "/" + (&url.URL{Path: targetFileName}).EscapedPath() + "#" + escapeFragment(id)
+   }
+   return relativeHref(fileName, path.Join(filepath.ToSlash(base), targetFileName)+"#"+id)
+}
+
+// Generate the solutions file newly (the old file is moved to the backup directory)
+func writeSolutionsFile() {
+   fileName := Configuration.SolutionsFileName // Links are relative to the solutions file in the book directory
+   storedFileName := Configuration.SolutionsFileName
+   if Configuration.OmitSolutions {
+      storedFileName = filepath.Join(Configuration.SolutionsDirectory, filepath.FromSlash(Configuration.SolutionsFileName))
+   }
+
+   // The head of the file is copied from the old solutions file or from the first section file
+   // (if OmitSolutions = true, the solutions file is removed from the book directory)
+   headFileName := backupFileName(fileName)
+   err := os.Rename(fileName, headFileName)
+   if os.IsNotExist(err) {
+      headFileName = Configuration.SectionsFileNames[0]
+   } else if err != nil {
+      log.Fatal(err)
+   } else if Configuration.OmitSolutions {
+      fmt.Printf("Solutions file \"%s\" removed from the book directory (OmitSolutions = true)\n", fileName)
+   }
+   if Configuration.OmitSolutions {
+      err = os.MkdirAll(filepath.Dir(storedFileName), 0755)
+      if err != nil {
+         log.Fatal(err)
+      }
+      if headFileName == Configuration.SectionsFileNames[0] && fileExists(storedFileName) {
+         headFileName = storedFileName
+      }
+   }
+   rawFile, err := ioutil.ReadFile(headFileName)
+   if err != nil {
+      log.Fatal(err)
+   }
+   head := string(rawFile)
+   iBody := strings.Index(head, beginBody)
+   if iBody < 0 {
+      fmt.Printf("Error: File \"%s\" does not contain \"%s\"\n", headFileName, beginBody)
+      os.Exit(1)
+   }
+   head = head[0 : iBody+len(beginBody)]
+   if headFileName == Configuration.SectionsFileNames[0] {
+      head = htmlTitle.ReplaceAllString(head, "<title>Solutions</title>")
+   }
+
+   file, err := os.Create(storedFileName)
+   if err != nil {
+      log.Fatal(err)
+   }
+   defer file.Close()
+   fmt.Println("Generate solutions file:", storedFileName)
+   fmt.Fprintln(file, head)
+
+   // Navigation bar (the solutions file is the last file of the book)
+   if !Configuration.OmitSolutions {
+      iSectionFile := len(BookStructure.SectionFiles) - 1
+      if iSectionFile > 0 {
+         ReqNav[1] = Configuration.SectionsFileNames[iSectionFile-1]
+      } else {
+         ReqNav[1] = Configuration.CoverFileName
+      }
+      ReqNav[2] = ""
+      writeNavigationBar(file, iSectionFile)
+   }
+   fmt.Fprintf(file, "\n<h1 id=\"%s\">Solutions</h1>\n", solutionsID)
+
+   // Solutions in the order of the exercises
+   written := make(map[string]bool)
+   for _, exercise := range CounterItems[exerciseCounter] {
+      solution, exists := Solutions[exercise.ID]
+      if !exists {
+         continue
+      }
+      label := Bookmarks[exercise.ID].Label
+      if label == "" {
+         label = "Exercise"
+      }
+      fmt.Fprintf(file, "<div class=\"solution\" id=\"sol_%s\">\n<p class=\"solution-title\"><a href=\"%s\">%s</a></p>\n%s\n</div>\n",
+         exercise.ID, relativeHref(fileName, exercise.FileName+"#"+exercise.ID), label, updateSolutionLinks(solution))
+      written[exercise.ID] = true
+   }
+
+   // Solutions of exercises that are no longer present are kept at the end of the file
+   orphans := make([]string, 0, 5)
+   for id := range Solutions {
+      if !written[id] {
+         orphans = append(orphans, id)
+      }
+   }
+   sort.Strings(orphans)
+   for _, id := range orphans {
+      fmt.Printf("Warning: Exercise \"%s\" of solution \"sol_%s\" not found (solution is kept in %s)\n", id, id, fileName)
+      fmt.Fprintf(file, "<div class=\"solution\" id=\"sol_%s\">\n<p class=\"solution-title\">Exercise \"%s\" not found</p>\n%s\n</div>\n",
+         id, id, Solutions[id])
+   }
+   writeContentsTail(file)
+}
+
+// Update the links in a solution with the actual file names, labels and tooltips of the link targets
+func updateSolutionLinks(solution string) string {
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader("<div>" + solution + "</div>"))
+   if err != nil {
+      log.Fatal(err)
+   }
+   doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+      href := s.AttrOr("href", "")
+      _, id, external := linkTarget(Configuration.SolutionsFileName, href)
+      if external || id == "" {
+         return
+      }
+      bookmark, present := Bookmarks[id]
+      if !present {
+         fmt.Printf("      Internal link in solution not resolved (wrong id?): <a href=\"%s\">%s<\\a>\n", href, s.Text())
+         return
+      }
+      s.SetAttr("href", relativeHref(Configuration.SolutionsFileName, bookmark.FileName+"#"+id))
+      if bookmark.Label != "" {
+         s.SetText(bookmark.Label)
+      }
+      if bookmark.Tooltip != "" {
+         s.SetAttr("title", bookmark.Tooltip)
+      }
+   })
+   newSolution, _ := doc.Find("body > div").First().Html()
+   return newSolution
+}
+
+// Index of the counter in Configuration.Counters that numbers element s (or -1, if there is no such counter)
+func counterIndex(s *goquery.Selection) int {
+   for i, counter := range Configuration.Counters {
+      if s.Is(counter.Selector) {
+         return i
+      }
+   }
+   return -1
+}
+
+// Reset all counters defined in the configuration file with the given reset scope ("chapter" or "section")
+func resetCounters(scope string) {
+   for i, counter := range Configuration.Counters {
+      if counter.Reset == scope || scope == "chapter" && counter.Reset == "" {
+         Counters.iCounters[i] = 0
+      }
+   }
+}
+
+// Text of a link to a bookmark in the reference style of the link (data-ref attribute) or of ReferenceStyle:
+//    "label" : e.g. "2.3", "Figure 3-2", "(2.1)"
+//    "Cref"  : e.g. "Section 2.3", "Figure 3-2", "Equation (2.1)" (default, if ReferenceStyle = "cleveref")
+//    "cref"  : e.g. "section 2.3", "figure 3-2", "equation (2.1)"
+//    "number": e.g. "2.3", "3-2", "(2.1)"
+// If the link has a data-ref-to attribute, a range is referenced, e.g. "Figures 3-2 to 3-4".
+// Returns "", if the text of the link shall not be changed.
+func referenceText(link ElementType, fileName string) string {
+   style := referenceStyle(link.RefStyle, fileName)
+   first, _ := lookupBookmark(link.ID)
+   text := bookmarkText(first, style, false)
+   if link.RefTo == "" || text == "" {
+      return text
+   }
+   last, present := lookupBookmark(link.RefTo)
+   if !present {
+      fmt.Printf("      Internal link not resolved (wrong id?): data-ref-to=\"%s\" in file %s\n", link.RefTo, fileName)
+      return text
+   }
+   if first.Kind != "" && first.Kind == last.Kind && style != "label" {
+      // Range of elements of the same kind, e.g. "Figures 3-2 to 3-4"
+      return bookmarkText(first, style, true) + " to " + last.Number
+   }
+   return text + " to " + bookmarkText(last, style, false)
+}
+
+// Reference style of a link with attribute data-ref="refStyle" in file fileName (the default style is
+// defined by ReferenceStyle; an unknown style is reported and "label" is used)
+func referenceStyle(refStyle string, fileName string) string {
+   if refStyle == "" {
+      if Configuration.ReferenceStyle == "cleveref" {
+         return "Cref"
+      }
+      return "label"
+   } else if refStyle != "label" && refStyle != "Cref" && refStyle != "cref" && refStyle != "number" {
+      fmt.Printf("      Warning: Unknown reference style data-ref=\"%s\" in file %s (must be \"label\", \"Cref\", \"cref\" or \"number\")\n",
+         refStyle, fileName)
+      return "label"
+   }
+   return refStyle
+}
+
+// Text of a link to a bookmark in the given reference style (see referenceText).
+// If plural = true, the plural of the kind is used (e.g. "Figures 3-2").
+func bookmarkText(bookmark BookmarkType, style string, plural bool) string {
+   if bookmark.Kind == "" || style == "label" {
+      return bookmark.Label
+   }
+   if style == "number" {
+      return bookmark.Number
+   }
+   return kindText(bookmark.Kind, style, plural) + " " + bookmark.Number
+}
+
+// Kind of a numbered element in the given reference style ("Cref": e.g. "Figure", "cref": e.g. "figure").
+// If plural = true, the plural of the kind is used (e.g. "Figures", "Appendices").
+func kindText(kind string, style string, plural bool) string {
+   if plural && strings.HasSuffix(kind, "ix") {
+      kind = kind[0:len(kind)-2] + "ices"
+   } else if plural {
+      kind = kind + "s"
+   }
+   if style == "cref" {
+      kind = strings.ToLower(kind)
+   }
+   return kind
+}
+
+// Content of a grouped reference <span class="refs" data-refs="ids"> in file fileName in the reference style
+// refStyle (see referenceText). The targets are grouped by kind (in the order of the first id of every kind in ids);
+// within a group, the labels are sorted in the order of the book and linked to their targets.
+// Three or more consecutive numbers are compressed to a range, e.g.
+//    "Sections <a ..>2.3</a>–<a ..>2.5</a>" or "Equations <a ..>(3.1)</a>, <a ..>(3.4)</a> and <a ..>(3.7)</a>"
+// Several groups are separated by commas and "and" is only used before the last group, e.g.
+//    "Equations <a ..>(3.1)</a>, <a ..>(3.4)</a> and Section <a ..>2.3</a>"
+// Returns the number of ids that are not resolved (content = "" in this case).
+func groupedReference(ids []string, refStyle string, fileName string) (content string, nErrors int) {
+   type itemType struct {
+      bookmark  BookmarkType
+      book      string // Prefix of the other book of the target (or "")
+      fileIndex int    // Index of the file of the target in the book (targets in other books are sorted after this book)
+      link      string // Link to the target, e.g. <a href="#eq_a" title="..">(3.1)</a>
+   }
+   style := referenceStyle(refStyle, fileName)
+   fileIndex := make(map[string]int)
+   for i, sectionFile := range BookStructure.SectionFiles {
+      fileIndex[sectionFile.FileName] = i
+   }
+
+   // Targets of the grouped reference (an id that is present twice is used once)
+   items := make([]itemType, 0, len(ids))
+   kinds := make([]string, 0, 2)
+   groups := make(map[string][]itemType)
+   used := make(map[string]bool)
+   for _, id := range ids {
+      if used[id] {
+         continue
+      }
+      used[id] = true
+      bookmark, present := lookupBookmark(id)
+      if !present {
+         fmt.Printf("      Internal link not resolved (wrong id?): <span class=\"refs\" data-refs=\"..%s..\"> in file %s\n", id, fileName)
+         nErrors++
+         continue
+      }
+      if _, present := groups[bookmark.Kind]; !present {
+         kinds = append(kinds, bookmark.Kind)
+         groups[bookmark.Kind] = nil
+      }
+      item := itemType{bookmark: bookmark}
+      var href string
+      if book, otherID, crossBook := crossBookReference(id); crossBook {
+         item.book = book
+         item.fileIndex = len(BookStructure.SectionFiles)
+         href = otherBookHref(fileName, book, bookmark.FileName, otherID)
+      } else {
+         item.fileIndex = fileIndex[bookmark.FileName]
+         href = linkHref(fileName, bookmark.FileName, id)
+      }
+      text := bookmark.Label
+      if bookmark.Kind != "" && bookmark.Number != "" {
+         text = bookmark.Number
+      }
+      item.link = linkStartTag("<a>", href, bookmark.Tooltip) + html.EscapeString(text) + "</a>"
+      items = append(items, item)
+   }
+   if nErrors > 0 {
+      return "", nErrors
+   }
+
+   // Sort the targets in the order of the book (numbers in the same file are compared numerically, e.g. "2.9" < "2.10")
+   sort.SliceStable(items, func(i, j int) bool {
+      if items[i].fileIndex != items[j].fileIndex {
+         return items[i].fileIndex < items[j].fileIndex
+      }
+      if items[i].book != items[j].book {
+         return items[i].book < items[j].book
+      }
+      if items[i].bookmark.FileName != items[j].bookmark.FileName {
+         return items[i].bookmark.FileName < items[j].bookmark.FileName
+      }
+      return compareNumbers(items[i].bookmark.Number, items[j].bookmark.Number) < 0
+   })
+
+   // Group the targets by kind
+   for _, item := range items {
+      groups[item.bookmark.Kind] = append(groups[item.bookmark.Kind], item)
+   }
+   groupTexts := make([]string, 0, len(kinds))
+   for _, kind := range kinds {
+      group := groups[kind]
+      parts := make([]string, 0, len(group))
+      for i := 0; i < len(group); {
+         // Run of consecutive numbers starting at group[i]
+         j := i
+         for j+1 < len(group) && kind != "" && group[j+1].book == group[j].book &&
+            consecutiveNumbers(group[j].bookmark.Number, group[j+1].bookmark.Number) {
+            j++
+         }
+         if j-i >= 2 {
+            parts = append(parts, group[i].link+"\u2013"+group[j].link)
+         } else {
+            j = i
+            parts = append(parts, group[i].link)
+         }
+         i = j + 1
+      }
+      // With several groups, "and" is only used before the last group (e.g. "Equations (1.1), (1.2) and Section 1.2")
+      text := enumerationText(parts)
+      if len(kinds) > 1 {
+         text = strings.Join(parts, ", ")
+      }
+      first := group[0].bookmark
+      if kind != "" && (style == "Cref" || style == "cref" || style == "label" && first.Label != first.Number) {
+         // The kind is shown once for all targets of the group, e.g. "Figures 3-2 and 3-4"
+         kindStyle := style
+         if style == "label" {
+            kindStyle = "Cref"
+         }
+         text = kindText(kind, kindStyle, len(group) > 1) + " " + text
+      }
+      groupTexts = append(groupTexts, text)
+   }
+   return enumerationText(groupTexts), 0
+}
+
+// Enumeration of parts, e.g. "a", "a and b", "a, b and c"
+func enumerationText(parts []string) string {
+   if len(parts) <= 1 {
+      return strings.Join(parts, "")
+   }
+   return strings.Join(parts[0:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
+
+// Compare numbers of numbered elements, where sequences of digits are compared numerically,
+// e.g. "2.9" < "2.10", "(3.4)" < "(3.4a)" < "(3.5)". Returns -1, 0 or +1.
+func compareNumbers(number1 string, number2 string) int {
+   for number1 != "" && number2 != "" {
+      n1 := len(number1) - len(strings.TrimLeft(number1, "0123456789"))
+      n2 := len(number2) - len(strings.TrimLeft(number2, "0123456789"))
+      if n1 > 0 && n2 > 0 {
+         i1, _ := strconv.Atoi(number1[0:n1])
+         i2, _ := strconv.Atoi(number2[0:n2])
+         if i1 != i2 {
+            if i1 < i2 {
+               return -1
+            }
+            return 1
+         }
+         number1, number2 = number1[n1:], number2[n2:]
+      } else if number1[0] != number2[0] {
+         if number1[0] < number2[0] {
+            return -1
+         }
+         return 1
+      } else {
+         number1, number2 = number1[1:], number2[1:]
+      }
+   }
+   return len(number1) - len(number2)
+}
+
+// = true, if number2 follows number1 directly, e.g. "2.3" and "2.4" or "(3.1)" and "(3.2)"
+func consecutiveNumbers(number1 string, number2 string) bool {
+   match1 := lastNumber.FindStringSubmatch(number1)
+   match2 := lastNumber.FindStringSubmatch(number2)
+   if match1 == nil || match2 == nil || match1[1] != match2[1] || match1[3] != match2[3] {
+      return false
+   }
+   i1, _ := strconv.Atoi(match1[2])
+   i2, _ := strconv.Atoi(match2[2])
+   return i2 == i1+1
+}
+
+// Store a bookmark. kind is the kind of a numbered element (e.g. "Section", "Figure", "Equation")
+// and is used for references in the style of cleveref (e.g. "Section 2.3"); kind = "" for other elements.
+func addBookmark(id string, fileName string, kind string, label string, tooltip string) {
+   key, present := Bookmarks[id]
+   if present {
+      // Duplicate id (not yet reported, if the id is not an id attribute, e.g. a \label{..} of an equation)
+      for _, duplicate := range DuplicateIDs {
+         if duplicate.ID == id && duplicate.Second.FileName == fileName {
+            return
+         }
+      }
+      DuplicateIDs = append(DuplicateIDs, DuplicateIDType{id,
+         IDLocationType{key.FileName, IDLines[key.FileName][id]}, IDLocationType{fileName, IDLines[fileName][id]}})
+   } else {
+      number := ""
+      if kind != "" {
+         number = strings.TrimPrefix(label, kind+" ")
+      }
+      Bookmarks[id] = BookmarkType{fileName, label, tooltipText(tooltip, kind, label), kind, number}
+   }
+}
+
+// Tooltip of links to an element from the text of the element (e.g. "2.3 <em>Operators</em>"): The plain text
+// is used (white space is collapsed), the label is removed if OmitTooltipNumbers = true (e.g. "Operators"),
+// and the text is shortened to TooltipLength characters.
+func tooltipText(text string, kind string, label string) string {
+   tooltip := text
+   if strings.ContainsAny(tooltip, "<&") {
+      tooltip = plainText(tooltip)
+   } else {
+      tooltip = strings.Join(strings.Fields(tooltip), " ")
+   }
+   if Configuration.OmitTooltipNumbers && kind != "" && kind != "Footnote" && label != "" && strings.HasPrefix(tooltip, label) {
+      tooltip = strings.TrimLeft(tooltip[len(label):], ": ")
+   }
+   characters := []rune(tooltip)
+   if Configuration.TooltipLength > 0 && len(characters) > Configuration.TooltipLength {
+      tooltip = strings.TrimSpace(string(characters[0:Configuration.TooltipLength-3])) + "..."
+   }
+   return tooltip
+}
+
+// = true, if two tooltips are identical apart from white space
+func sameTooltip(tooltip1 string, tooltip2 string) bool {
+   return strings.Join(strings.Fields(tooltip1), " ") == strings.Join(strings.Fields(tooltip2), " ")
+}
+
+// Integer minimum
+func minInt(a, b int) int {
+   if a <= b {
+      return a
+   } else {
+      return b
+   }
+}
+
+// Number of sections that have a section number
+func numberedSections(sections []SectionType) int {
+   n := 0
+   for _, section := range sections {
+      if !section.Unnumbered {
+         n++
+      }
+   }
+   return n
+}
+
+// Appendix number as letters: 1 -> "A", 2 -> "B", ..., 26 -> "Z", 27 -> "AA", 28 -> "AB", ...
+func appendixLetters(n int) string {
+   str := ""
+   for n > 0 {
+      n--
+      str = string(letters[n%len(letters)]) + str
+      n = n / len(letters)
+   }
+   return str
+}
+
+// Appendix letters as number: "A" -> 1, "B" -> 2, ..., "Z" -> 26, "AA" -> 27, ...
+// Returns 0, if str is empty or contains other characters as A-Z.
+func appendixNumber(str string) int {
+   n := 0
+   for _, c := range str {
+      if c < 'A' || c > 'Z' {
+         return 0
+      }
+      n = n*len(letters) + int(c-'A') + 1
+   }
+   return n
+}
+
+// Letters of the actual appendix (e.g. "B" or "AB").
+// An error is printed and the program terminates, if no appendix is active.
+func actualAppendixLetters() string {
+   if Counters.last_h1_type == "FrontMatter" {
+      return Counters.frontMatter
+   }
+   if Counters.ih1_letter < 1 {
+      fmt.Printf("Error: Appendix number requested, but appendix counter is %d (no <h1> starting with \"Appendix\" processed?)\n",
+         Counters.ih1_letter)
+      os.Exit(1)
+   }
+   return appendixLetters(Counters.ih1_letter)
+}
+
+// Update text with correct section number
+func updateSectionText(text string, level, nr2, nr3, nr4 int) (newText string, modified bool, label string) {
+   // If section needs not to be numbered, return (the <h1> of front matter is not numbered)
+   if Counters.last_h1_type == "" || Counters.last_h1_type == "FrontMatter" && level == 1 {
+      newText = text
+      modified = false
+      label = plainText(text) // e.g. "Preface & Outlook" for "Preface &amp; <em>Outlook</em>"
+      return
+   }
+
+   // Section number needs to be numbered
+   var secStr string // Required section number as string
+
+   // Determine required section number
+   if Counters.last_h1_type == "Chapter" {
+      switch level {
+      case 1:
+         secStr = fmt.Sprintf("Chapter %d ", Counters.ih1_digit)
+      case 2:
+         secStr = fmt.Sprintf("%d.%d ", Counters.ih1_digit, nr2)
+      case 3:
+         secStr = fmt.Sprintf("%d.%d.%d ", Counters.ih1_digit, nr2, nr3)
+      case 4:
+         secStr = fmt.Sprintf("%d.%d.%d.%d ", Counters.ih1_digit, nr2, nr3, nr4)
+      default:
+         fmt.Printf("Wrong argument level (= %d) when calling function updateText.\nMust be 1,2,3 or 4\n", level)
+         os.Exit(1)
+      }
+   } else {
+      h1_letter := actualAppendixLetters()
+      switch level {
+      case 1:
+         secStr = fmt.Sprintf("Appendix %s ", h1_letter)
+      case 2:
+         secStr = fmt.Sprintf("%s.%d ", h1_letter, nr2)
+      case 3:
+         secStr = fmt.Sprintf("%s.%d.%d ", h1_letter, nr2, nr3)
+      case 4:
+         secStr = fmt.Sprintf("%s.%d.%d.%d ", h1_letter, nr2, nr3, nr4)
+      default:
+         fmt.Printf("Wrong argument level (= %d) when calling function updateText.\nMust be 1,2,3 or 4\n", level)
+         os.Exit(1)
+      }
+   }
+   label = secStr[0 : len(secStr)-1]
+
+   // Has text the required section number?
+   isec := minInt(len(secStr), len(text))
+   if text[0:isec] == secStr {
+      // text has the required section number
+      newText = text
+      modified = false
+
+   } else {
+      // text has no or wrong section number -> correct section number
+      var index []int
+      byteText := []byte(text)
+
+      if Counters.last_h1_type == "Chapter" {
+         switch level {
+         case 1:
+            index = validSection1.FindIndex(byteText)
+         case 2:
+            index = validSection2.FindIndex(byteText)
+         case 3:
+            index = validSection3.FindIndex(byteText)
+         case 4:
+            index = validSection4.FindIndex(byteText)
+         }
+      } else {
+         switch level {
+         case 1:
+            index = validSection1_Appendix.FindIndex(byteText)
+         case 2:
+            index = validSection2_Appendix.FindIndex(byteText)
+         case 3:
+            index = validSection3_Appendix.FindIndex(byteText)
+         case 4:
+            index = validSection4_Appendix.FindIndex(byteText)
+         }
+      }
+
+      if index == nil {
+         // no Section number was present
+         newText = secStr + text
+         fmt.Println("      Section number added:", newText)
+      } else {
+         // Section number was present: replace it with correct one
+         newText = secStr + string(byteText[index[1]:])
+         fmt.Println("      Section number updated:", newText)
+      }
+      modified = true
+   }
+   return
+}
+
+// Update text with correct caption number
+// If letter != "", the figcaption is a subfigure (e.g. text "(b) ..." and label "Figure 3-2b").
+func updateCaptionText(text string, fig bool, nrCap int, letter string) (newText string, modified bool, label string) {
+   // If caption needs not to be numbered, return
+   if Counters.last_h1_type == "" {
+      newText = text
+      modified = false
+      label = plainText(text)
+      return
+   }
+
+   // Caption number needs to be numbered
+   var capStr string // Required caption number as string
+
+   // Determine required caption number
+   if Counters.last_h1_type == "Chapter" {
+      if fig {
+         capStr = fmt.Sprintf("Figure %d-%d: ", Counters.ih1_digit, nrCap)
+      } else {
+         capStr = fmt.Sprintf("Table %d-%d: ", Counters.ih1_digit, nrCap)
+      }
+   } else {
+      h1_letter := actualAppendixLetters()
+      if fig {
+         capStr = fmt.Sprintf("Figure %s-%d: ", h1_letter, nrCap)
+      } else {
+         capStr = fmt.Sprintf("Table %s-%d: ", h1_letter, nrCap)
+      }
+   }
+   label = capStr[0 : len(capStr)-2]
+   if letter != "" {
+      label = label + letter
+      capStr = "(" + letter + ") "
+   }
+
+   // Has text the required caption number?
+   icap := minInt(len(capStr), len(text))
+   if text[0:icap] == capStr {
+      // text has the required caption number
+      newText = text
+      modified = false
+
+   } else {
+      // text has no or wrong caption number -> correct caption number
+      var index []int
+      byteText := []byte(text)
+
+      if Counters.last_h1_type == "Chapter" {
+         if fig {
+            index = validFigCaption.FindIndex(byteText)
+         } else {
+            index = validCaption.FindIndex(byteText)
+         }
+      } else {
+         if fig {
+            index = validFigCaption_Appendix.FindIndex(byteText)
+         } else {
+            index = validCaption_Appendix.FindIndex(byteText)
+         }
+      }
+      if fig && index == nil {
+         index = validSubfigure.FindIndex(byteText)
+      }
+
+      if index == nil {
+         // no caption number was present
+         newText = capStr + text
+         fmt.Println("      Caption number added:", newText)
+      } else {
+         // Caption number was present: replace it with correct one
+         newText = capStr + string(byteText[index[1]:])
+         fmt.Println("      Caption number updated:", newText)
+      }
+      modified = true
+   }
+   return
+}
+
+// Update text of an element numbered by counter Configuration.Counters[iCounter] with the correct number,
+// e.g. "Definition 2-3: ". The number is introduced after leading white space and a leading <p> tag.
+// nr2 is the number of the actual <h2> section (used for {section} in the number format).
+func updateCounterText(text string, iCounter int, nr2 int) (newText string, modified bool, label string) {
+   counter := Configuration.Counters[iCounter]
+
+   // If element needs not to be numbered, return
+   if Counters.last_h1_type == "" && counter.Reset != "book" {
+      newText = text
+      modified = false
+      label = ""
+      return
+   }
+
+   // Determine required number
+   var h1Str string
+   if Counters.last_h1_type == "Chapter" {
+      h1Str = strconv.Itoa(Counters.ih1_digit)
+   } else if Counters.last_h1_type != "" {
+      h1Str = actualAppendixLetters()
+   }
+   number := strings.Replace(counter.Format, "{chapter}", h1Str, -1)
+   number = strings.Replace(number, "{section}", strconv.Itoa(nr2), -1)
+   number = strings.Replace(number, "{n}", strconv.Itoa(Counters.iCounters[iCounter]), -1)
+   label = counter.Label + " " + number
+   numStr := label + ": "
+
+   // Has text the required number?
+   iStart := len(counterTextStart.FindString(text))
+   if strings.HasPrefix(text[iStart:], numStr) {
+      newText = text
+      modified = false
+      return
+   }
+
+   // text has no or wrong number -> correct number
+   index := counterRegexps[iCounter].FindStringIndex(text[iStart:])
+   if index == nil {
+      newText = text[0:iStart] + numStr + text[iStart:]
+      fmt.Printf("      %s number added: %s\n", counter.Label, newText)
+   } else {
+      newText = text[0:iStart] + numStr + text[iStart+index[1]:]
+      fmt.Printf("      %s number updated: %s\n", counter.Label, newText)
+   }
+   modified = true
+   return
+}
+
+// Update text with correct equation number
+// If letter != "", the equation is part of subequations (e.g. "(2.3b)").
+func updateEquationText(text string, letter string) (newText string, modified bool, label string) {
+   // If section needs not to be numbered, return
+   if Counters.last_h1_type == "" {
+      newText = text
+      modified = false
+      label = ""
+      return
+   }
+
+   // Equation number needs to be numbered
+   var eqStr string // Required equation number as string
+
+   // Determine required equation number
+   eqStr = "(" + equationNumber() + letter + ")"
+   label = eqStr
+
+   if Configuration.EquationNumberStyle == "tag" {
+      // Remove equation number in front of the equation and introduce \tag{..} at the end of the equation
+      str := equationPrefix.ReplaceAllString(text, "$1")
+      str = generatedEquationTag.ReplaceAllString(str, "")
+      iStart := equationStart.FindStringIndex(str)
+      iEnd := strings.LastIndex(str, "$$")
+      if iStart == nil || iEnd < iStart[1] {
+         fmt.Printf("Error: <div class=\"equation\" ...> present, but no \"$$ ... $$\" to mark the equation\n")
+         os.Exit(1)
+      }
+      newText = strings.TrimRight(str[0:iEnd], " ") + ` \tag{` + eqStr[1:len(eqStr)-1] + `} ` + str[iEnd:]
+      if newText == text {
+         modified = false
+      } else {
+         fmt.Println("      Equation number updated:", newText)
+         modified = true
+      }
+      return
+   }
+   text = generatedEquationTag.ReplaceAllString(text, "")
+
+   // Has text the required equation number?
+   byteText := []byte(text)
+   var index []int
+   if Counters.last_h1_type == "Chapter" {
+      index = validEquation.FindIndex(byteText)
+   } else {
+      index = validEquation_Appendix.FindIndex(byteText)
+   }
+
+   if index == nil {
+      // No valid equation number present, add a new one
+      index = equationStart.FindIndex(byteText) // find "$$"
+      if index == nil {
+         fmt.Printf("Error: <div class=\"equation\" ...> present, but no \"$$\" to mark equation start\n")
+         os.Exit(1)
+      }
+      newText = text[0:index[1]] + " " + eqStr + ` \;\;\;\;\; ` + text[index[1]:]
+      fmt.Println("      Equation number added:", newText)
+      modified = true
    } else {
-      return b
+      // Check whether equation number is correct
+      iEnd := index[1]
+      index = withEquationNumber.FindIndex(byteText) // find "$$ ("
+      iBegin := index[1] - 1
+      if text[iBegin:iEnd] == eqStr {
+         // text has the required equation number
+         newText = text
+         modified = false
+      } else {
+         // text has no or wrong equation number -> correct equation number
+         newText = text[0:iBegin] + " " + eqStr + text[iEnd:]
+         fmt.Println("      Equation number updated:", newText)
+         modified = true
+      }
    }
+   return
 }
 
-// Update text with correct section number
-func updateSectionText(text string, level, nr2, nr3, nr4 int) (newText string, modified bool, label string) {
-   // If section needs not to be numbered, return
+// Update multi-line equation (align, eqnarray, gather environment) with equation numbers.
+// Every row gets a number via \tag{..}, with exception of rows marked with \nonumber or \notag
+// and of rows with an own \tag (e.g. \tag{*}).
+// If the equation is in a <div class="subequations"> container, all rows get the equation number
+// of the container together with a letter (e.g. 2.3a, 2.3b).
+// The \label{rowID} of the rows are returned in rows, in order that they can be referenced.
+func updateMultiLineEquationText(text string) (newText string, modified bool, label string, rows []EquationRowType) {
+   // If equation needs not to be numbered, return
    if Counters.last_h1_type == "" {
       newText = text
       modified = false
-      label = text
+      label = ""
       return
    }
 
-   // Section number needs to be numbered
-   var secStr string // Required section number as string
+   // Remove a single line equation number (e.g. "$$ (2.3) \;\;\;")
+   str := equationPrefix.ReplaceAllString(text, "$1")
 
-   // Determine required section number
+   // Split environment into rows and number every row
+   index := multiLineEquation.FindStringSubmatchIndex(str)
+   envName := str[index[2]:index[3]]
+   iBody := index[1]
+   iEnd := strings.Index(str[iBody:], `\end{`+envName)
+   if iEnd < 0 {
+      fmt.Printf("Error: \\begin{%s} without \\end{%s} in equation:\n   %s\n", envName, envName, text)
+      os.Exit(1)
+   }
+   iEnd = iBody + iEnd
+
+   body := ""
+   iRow := 0
+   for _, row := range splitEquationRows(str[iBody:iEnd]) {
+      if strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(row), `\\`)) == "" {
+         // Empty row (e.g. after a final "\\")
+         body = body + row
+         continue
+      }
+      row = generatedEquationTag.ReplaceAllString(row, "")
+      if ownEquationTag(row) {
+         body = body + row
+         continue
+      }
+
+      // Determine equation number of row
+      var tag string
+      if Counters.subequations != nil {
+         Counters.iSubequation++
+         tag = equationNumber() + subLetter(Counters.iSubequation)
+      } else {
+         Counters.iEquation++
+         tag = equationNumber()
+      }
+      if iRow == 0 {
+         label = "(" + tag + ")"
+      }
+      iRow++
+
+      rowID := ""
+      match := equationLabel.FindStringSubmatch(row)
+      if match != nil {
+         rowID = html.UnescapeString(match[1]) // e.g. "\label{a&amp;b}" in the html text is the id "a&b"
+      }
+      rows = append(rows, EquationRowType{rowID, "(" + tag + ")"})
+
+      // Introduce \tag{..} at the end of the row (before trailing white space)
+      trimmed := strings.TrimRight(row, " \t\r\n")
+      body = body + trimmed + ` \tag{` + tag + `}` + row[len(trimmed):]
+   }
+
+   newText = str[0:iBody] + body + str[iEnd:]
+
+   if newText != text {
+      fmt.Println("      Equation numbers updated:", newText)
+      modified = true
+   }
+   return
+}
+
+// = true, if the tex of an equation (or of a row of a multi-line equation) has a \tag{..} that was not
+// generated by makeWebBook (e.g. \tag{*}) or is marked by \nonumber or \notag
+func ownEquationTag(tex string) bool {
+   return equationNoNumber.MatchString(tex) || equationTag.MatchString(generatedEquationTag.ReplaceAllString(tex, ""))
+}
+
+// Introduce anchors <span class="equation-anchor" id="rowID"></span> before the equation
+// for all rows with a \label{rowID}, in order that rowID can be referenced in a link.
+// No anchor is needed if rowID is the id of the equation element.
+func setEquationAnchors(text string, rows []EquationRowType, id string) string {
+   anchors := ""
+   for _, row := range rows {
+      if row.ID != "" && row.ID != id {
+         anchors = anchors + `<span class="equation-anchor" id="` + html.EscapeString(row.ID) + `"></span>`
+      }
+   }
+   if anchors == "" {
+      return text
+   }
+   iStart := equationStart.FindStringIndex(text)
+   if iStart == nil {
+      fmt.Printf("Error: <div class=\"equation\" ...> present, but no \"$$\" to mark equation start\n")
+      os.Exit(1)
+   }
+   return text[0:iStart[0]] + anchors + text[iStart[0]:]
+}
+
+// Actual equation number without parentheses (e.g. "2.3" or "B.3")
+func equationNumber() string {
    if Counters.last_h1_type == "Chapter" {
-      switch level {
-      case 1:
-         secStr = fmt.Sprintf("Chapter %d ", Counters.ih1_digit)
-      case 2:
-         secStr = fmt.Sprintf("%d.%d ", Counters.ih1_digit, nr2)
-      case 3:
-         secStr = fmt.Sprintf("%d.%d.%d ", Counters.ih1_digit, nr2, nr3)
-      case 4:
-         secStr = fmt.Sprintf("%d.%d.%d.%d ", Counters.ih1_digit, nr2, nr3, nr4)
-      default:
-         fmt.Printf("Wrong argument level (= %d) when calling function updateText.\nMust be 1,2,3 or 4\n", level)
+      return fmt.Sprintf("%d.%d", Counters.ih1_digit, Counters.iEquation)
+   }
+   return fmt.Sprintf("%s.%d", actualAppendixLetters(), Counters.iEquation)
+}
+
+// Lower case roman numeral: 1 -> "i", 2 -> "ii", 4 -> "iv", ...
+func romanNumeral(n int) string {
+   values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+   numerals := []string{"m", "cm", "d", "cd", "c", "xc", "l", "xl", "x", "ix", "v", "iv", "i"}
+   str := ""
+   for i, value := range values {
+      for n >= value {
+         str = str + numerals[i]
+         n = n - value
+      }
+   }
+   return str
+}
+
+// Letter of a subequation or subfigure: 1 -> "a", 2 -> "b", ..., 27 -> "aa", ...
+func subLetter(n int) string {
+   return strings.ToLower(appendixLetters(n))
+}
+
+// Split the body of a multi-line equation environment at "\\" into rows.
+// "\\" within braces or within nested environments (e.g. a matrix) does not split a row.
+// With exception of the first row, every row starts with the "\\" that separates it from the previous row.
+func splitEquationRows(body string) []string {
+   rows := make([]string, 0, 5)
+   depth := 0 // nesting depth of braces and environments
+   iRow := 0
+   for i := 0; i < len(body); i++ {
+      switch {
+      case strings.HasPrefix(body[i:], `\begin{`):
+         depth++
+         i = i + len(`\begin`) - 1
+      case strings.HasPrefix(body[i:], `\end{`):
+         depth--
+         i = i + len(`\end`) - 1
+      case strings.HasPrefix(body[i:], `\\`):
+         if depth == 0 {
+            rows = append(rows, body[iRow:i])
+            iRow = i
+         }
+         i++
+      case body[i] == '\\':
+         i++ // skip escaped character, e.g. "\{"
+      case body[i] == '{':
+         depth++
+      case body[i] == '}':
+         depth--
+      }
+   }
+   rows = append(rows, body[iRow:])
+   return rows
+}
+
+// Check the links of the section documents and report unreferenced targets and the reference order
+// (exits with a non-zero exit code before any file is changed, if an error is found)
+func checkSectionDocuments() {
+   // Check all internal links (in strict mode, no file is changed if a link is broken)
+   fmt.Printf("\nCheck links:\n")
+   nErrors := 0
+   for iSectionFile, sectionFile := range BookStructure.SectionFiles {
+      if !sectionFile.Generated {
+         nErrors = nErrors + checkLinksOfOneFile(iSectionFile)
+      }
+   }
+   if nErrors > 0 && StrictLinkCheck {
+      fmt.Printf("Error: %d broken internal link(s) found (option -strict); no file was changed\n", nErrors)
+      os.Exit(1)
+   }
+
+   // Report figures, tables, equations and references that are never referenced
+   if Configuration.UnreferencedTargets == "warn" || Configuration.UnreferencedTargets == "error" {
+      nUnreferenced := reportUnreferencedTargets()
+      if nUnreferenced > 0 && Configuration.UnreferencedTargets == "error" {
+         fmt.Printf("Error: %d unreferenced figure(s), table(s), equation(s) or reference(s) found (UnreferencedTargets = \"error\"); no file was changed\n", nUnreferenced)
          os.Exit(1)
       }
-   } else {
-      h1_letter := string(letters[Counters.ih1_letter-1])
-      switch level {
-      case 1:
-         secStr = fmt.Sprintf("Appendix %s ", h1_letter)
-      case 2:
-         secStr = fmt.Sprintf("%s.%d ", h1_letter, nr2)
-      case 3:
-         secStr = fmt.Sprintf("%s.%d.%d ", h1_letter, nr2, nr3)
-      case 4:
-         secStr = fmt.Sprintf("%s.%d.%d.%d ", h1_letter, nr2, nr3, nr4)
-      default:
-         fmt.Printf("Wrong argument level (= %d) when calling function updateText.\nMust be 1,2,3 or 4\n", level)
+   }
+
+   // Report figures and tables that are referenced only after they appear or far away from their first reference
+   if Configuration.ReferenceOrder == "warn" || Configuration.ReferenceOrder == "error" {
+      nWrongOrder := reportReferenceOrder()
+      if nWrongOrder > 0 && Configuration.ReferenceOrder == "error" {
+         fmt.Printf("Error: %d figure(s) or table(s) with wrong reference order found (ReferenceOrder = \"error\"); no file was changed\n", nWrongOrder)
          os.Exit(1)
       }
    }
-   label = secStr[0 : len(secStr)-1]
+}
 
-   // Has text the required section number?
-   isec := minInt(len(secStr), len(text))
-   if text[0:isec] == secStr {
-      // text has the required section number
-      newText = text
-      modified = false
+// Update section documents with changed section or caption numbers,
+// introducing missing element id's etc.
+func updateSectionDocuments() {
+   fmt.Printf("\nChange documents:\n")
+   for iSectionFile, sectionFile := range BookStructure.SectionFiles {
+      if sectionFile.Generated {
+         continue
+      }
+      fmt.Printf("   %s\n", sectionFile.FileName)
 
-   } else {
-      // text has no or wrong section number -> correct section number
-      var index []int
-      byteText := []byte(text)
+      // If file has to be modified, modify it
+      if sectionFile.Modified || sectionFile.NewNav || sectionFile.UpdateNav {
+         // Section document needs to be modified; move the file to the backup directory
+         movedFileName := backupFileName(sectionFile.FileName)
+         err := os.Rename(sectionFile.FileName, movedFileName)
+         if err != nil {
+            log.Fatal(err)
+         }
 
-      if Counters.last_h1_type == "Chapter" {
-         switch level {
-         case 1:
-            index = validSection1.FindIndex(byteText)
-         case 2:
-            index = validSection2.FindIndex(byteText)
-         case 3:
-            index = validSection3.FindIndex(byteText)
-         case 4:
-            index = validSection4.FindIndex(byteText)
+         // Generate the file newly
+         updateOneSectionDocument(movedFileName, sectionFile, iSectionFile)
+      }
+   }
+}
+
+// Check that the ids of a file are unique in the file, that no id of the file is the id of a bookmark
+// of a previous file, and that the ids consist only of recommended characters. With option -fix-duplicate-ids,
+// the later occurrence of a duplicate id is renamed, and with option -normalize-ids, an id with other characters
+// is normalized; the source with the renamed ids is returned. Otherwise, the duplicate ids are stored in
+// DuplicateIDs and ids with other characters are reported.
+func checkIDs(fileName string, source string) string {
+   lines := make(map[string]int)
+   IDLines[fileName] = lines
+   allIDs := make(map[string]bool)
+   for _, match := range idAttribute.FindAllStringSubmatch(source, -1) {
+      allIDs[html.UnescapeString(match[2]+match[3])] = true
+   }
+
+   renamed := ""
+   iLast := 0
+   for _, match := range idAttribute.FindAllStringSubmatchIndex(source, -1) {
+      // match[4:6] or match[6:8]: value of the id attribute
+      iValue, iValueEnd := match[4], match[5]
+      if iValue < 0 {
+         iValue, iValueEnd = match[6], match[7]
+      }
+      id := html.UnescapeString(source[iValue:iValueEnd]) // e.g. "&quot;" is replaced by `"`
+      if id == "" {
+         continue
+      }
+      line := strings.Count(source[0:match[0]], "\n") + 1
+      second := IDLocationType{fileName, line}
+      valid := validID.MatchString(id)
+      if !valid && !NormalizeIDs {
+         fmt.Printf("Warning: id=\"%s\" (%s) contains characters other than A-Z, a-z, 0-9, \"-_.:\" (use option -normalize-ids)\n", id, second)
+      }
+
+      var first IDLocationType
+      duplicate := true
+      if firstLine, present := lines[id]; present {
+         // id present twice in the file
+         first = IDLocationType{fileName, firstLine}
+      } else if bookmark, present := Bookmarks[id]; present && bookmark.FileName != fileName {
+         // id of a bookmark of a previous file
+         first = IDLocationType{bookmark.FileName, IDLines[bookmark.FileName][id]}
+      } else {
+         duplicate = false
+      }
+      if duplicate && !FixDuplicateIDs {
+         DuplicateIDs = append(DuplicateIDs, DuplicateIDType{id, first, second})
+         continue
+      } else if !duplicate && (valid || !NormalizeIDs) {
+         lines[id] = line
+         continue
+      }
+
+      // Rename the id (duplicate id or id with characters that are not recommended)
+      base := id
+      if !valid && NormalizeIDs {
+         base = invalidIDCharacters.ReplaceAllString(id, "_")
+      }
+      newID := base
+      for i := 2; newID == id || allIDs[newID] || Bookmarks[newID].FileName != ""; i++ {
+         newID = base + "_" + strconv.Itoa(i)
+      }
+      allIDs[newID] = true
+      lines[newID] = line
+      renamed = renamed + source[iLast:iValue] + html.EscapeString(newID) // e.g. `"` is written as "&#34;"
+      iLast = iValueEnd
+      if duplicate && first.FileName == fileName {
+         fmt.Printf("      Duplicate id renamed (first occurrence: line %d; links are not changed): id=\"%s\" -> id=\"%s\" (line %d)\n",
+            first.Line, id, newID, line)
+         continue
+      }
+
+      // Links to the id in this file clearly refer to this occurrence
+      if RenamedIDs[fileName] == nil {
+         RenamedIDs[fileName] = make(map[string]string)
+      }
+      RenamedIDs[fileName][id] = newID
+      if duplicate {
+         fmt.Printf("      Duplicate id renamed (first occurrence: %s): id=\"%s\" -> id=\"%s\" (line %d)\n", first, id, newID, line)
+      } else {
+         fmt.Printf("      id normalized: id=\"%s\" -> id=\"%s\" (line %d)\n", id, newID, line)
+      }
+   }
+   if iLast == 0 {
+      return source
+   }
+   return renamed + source[iLast:]
+}
+
+// Location as string, e.g. "chapter_01.html:12"
+func (location IDLocationType) String() string {
+   if location.Line == 0 {
+      return location.FileName
+   }
+   return location.FileName + ":" + strconv.Itoa(location.Line)
+}
+
+// Print the figures, tables, equations and entries of reference lists that are not referenced
+// by a link of the book (grouped by file). Returns the number of unreferenced elements.
+func reportUnreferencedTargets() int {
+   index := targetIndices()
+   representative := representativeTargets()
+   referenced := make([]bool, len(Targets))
+   reference := func(id string, to string) {
+      for _, i := range referencedTargets(index, representative, id, to) {
+         referenced[i] = true
+      }
+   }
+
+   // Links in the section files and in the solutions
+   for _, sectionFile := range BookStructure.SectionFiles {
+      for _, element := range sectionFile.Elements {
+         if element.StartTag == "<a" && element.ID != "" {
+            reference(element.ID, element.RefTo)
+         } else if element.StartTag == groupedReferenceTag {
+            for _, id := range strings.Fields(element.ID) {
+               reference(id, "")
+            }
+         }
+      }
+      for _, footnote := range sectionFile.Footnotes {
+         for _, link := range fragmentLinks(footnote.Text, sectionFile.FileName) {
+            reference(link.ID, link.RefTo)
+         }
+      }
+   }
+   for _, solution := range Solutions {
+      for _, link := range fragmentLinks(solution, Configuration.SolutionsFileName) {
+         reference(link.ID, link.RefTo)
+      }
+   }
+
+   fmt.Printf("\nUnreferenced figures, tables, equations and references:\n")
+   nUnreferenced := 0
+   fileName := ""
+   for i, target := range Targets {
+      if referenced[i] || representative[i] != i {
+         continue
+      }
+      if target.FileName != fileName {
+         fileName = target.FileName
+         fmt.Printf("   %s\n", fileName)
+      }
+      fmt.Printf("      %s (id=\"%s\")\n", reportLabel(target), target.ID)
+      nUnreferenced++
+   }
+   return nUnreferenced
+}
+
+// Print the figures and tables that are first referenced after they appear, first referenced in another chapter,
+// or placed more than Configuration.MaxSectionDistance sections away from their first reference (grouped by file).
+// Returns the number of figures and tables with a wrong reference order.
+func reportReferenceOrder() int {
+   // Position of an element in the book
+   type positionType struct {
+      element  int    // Index of the element in the book
+      section  int    // Index of the section (h1 - h4) in the book
+      chapter  int    // Index of the h1 section in the book
+      fileName string // File in which the element is present
+   }
+
+   // Positions of the targets and of their first references (in the order of the book)
+   index := targetIndices()
+   representative := representativeTargets()
+   targetPosition := make(map[int]positionType)
+   firstReference := make(map[int]positionType)
+   position := positionType{-1, -1, -1, ""}
+   reference := func(id string, to string) {
+      for _, i := range referencedTargets(index, representative, id, to) {
+         if _, present := firstReference[i]; !present {
+            firstReference[i] = position
+         }
+      }
+   }
+   for _, sectionFile := range BookStructure.SectionFiles {
+      if sectionFile.Generated {
+         continue
+      }
+      position.fileName = sectionFile.FileName
+      for _, element := range sectionFile.Elements {
+         position.element++
+         if iFootnote := footnoteIndex(sectionFile, element); iFootnote >= 0 {
+            // Links in a footnote text are references at the position of the footnote reference
+            for _, link := range fragmentLinks(sectionFile.Footnotes[iFootnote].Text, sectionFile.FileName) {
+               reference(link.ID, link.RefTo)
+            }
+            continue
+         }
+         switch element.StartTag {
+         case "<h1":
+            position.chapter++
+            position.section++
+         case "<h2", "<h3", "<h4":
+            position.section++
+         case "<a", groupedReferenceTag:
+            ids := []string{element.ID}
+            if element.StartTag == groupedReferenceTag {
+               ids = strings.Fields(element.ID)
+            }
+            for _, id := range ids {
+               if id != "" {
+                  reference(id, element.RefTo)
+               }
+            }
+            continue
+         }
+         i, present := index[element.ID]
+         if present && element.ID == Targets[i].ID && representative[i] == i {
+            targetPosition[i] = position
+         }
+      }
+   }
+
+   fmt.Printf("\nReference order of figures and tables:\n")
+   nWrongOrder := 0
+   fileName := ""
+   for i, target := range Targets {
+      if target.Kind != "Figure" && target.Kind != "Table" {
+         continue
+      }
+      targetPos, present := targetPosition[i]
+      first, referenced := firstReference[i]
+      if !present || !referenced {
+         // Unreferenced targets are reported with UnreferencedTargets
+         continue
+      }
+      messages := make([]string, 0, 3)
+      if first.element > targetPos.element {
+         messages = append(messages, "referenced only after it appears")
+      }
+      if first.chapter != targetPos.chapter {
+         messages = append(messages, "first referenced in another chapter (file "+first.fileName+")")
+      }
+      distance := targetPos.section - first.section
+      if distance < 0 {
+         distance = -distance
+      }
+      if Configuration.MaxSectionDistance > 0 && distance > Configuration.MaxSectionDistance {
+         messages = append(messages, fmt.Sprintf("placed %d sections away from its first reference", distance))
+      }
+      if len(messages) == 0 {
+         continue
+      }
+      if target.FileName != fileName {
+         fileName = target.FileName
+         fmt.Printf("   %s\n", fileName)
+      }
+      fmt.Printf("      %s (id=\"%s\"): %s\n", reportLabel(target), target.ID, strings.Join(messages, "; "))
+      nWrongOrder++
+   }
+   return nWrongOrder
+}
+
+// Index of every target in Targets (key: id of the target or of one of its aliases)
+func targetIndices() map[string]int {
+   index := make(map[string]int)
+   for i, target := range Targets {
+      index[target.ID] = i
+      for _, alias := range target.Aliases {
+         index[alias] = i
+      }
+   }
+   return index
+}
+
+// Index of the target that represents every target of Targets in the reports: a subfigure or subequation is
+// represented by its parent figure, or by the first subequation, if the parent has no target (e.g. equations
+// in a <div class="subequations"> container), so that a reference to a subfigure references its parent.
+func representativeTargets() []int {
+   parents := make(map[string]int) // Index of the target of a parent (key: file name and label)
+   for i, target := range Targets {
+      if target.Parent == "" {
+         parents[target.FileName+"#"+target.Label] = i
+      }
+   }
+   representative := make([]int, len(Targets))
+   for i, target := range Targets {
+      representative[i] = i
+      if target.Parent != "" {
+         key := target.FileName + "#" + target.Parent
+         if parent, present := parents[key]; present {
+            representative[i] = parent
+         } else {
+            parents[key] = i
+         }
+      }
+   }
+   return representative
+}
+
+// Label of a target in the reports (the label of the parent, if the target represents the subequations of a parent)
+func reportLabel(target TargetType) string {
+   if target.Parent != "" {
+      return target.Parent
+   }
+   return target.Label
+}
+
+// Indices of the targets referenced by a link to id (see representativeTargets). A range of references with
+// data-ref-to = to (e.g. <a href="#fig_a" data-ref-to="fig_c">) references all targets of the same kind from
+// the first to the last one.
+func referencedTargets(index map[string]int, representative []int, id string, to string) []int {
+   first, present := index[id]
+   if !present {
+      return nil
+   }
+   last, present := index[to]
+   if !present || last < first {
+      last = first
+   }
+   indices := make([]int, 0, last-first+1)
+   for i := first; i <= last; i++ {
+      // Targets of other kinds between first and last (e.g. tables in a range of figures) are not referenced
+      if Targets[i].Kind == Targets[first].Kind &&
+         (len(indices) == 0 || indices[len(indices)-1] != representative[i]) {
+         indices = append(indices, representative[i])
+      }
+   }
+   return indices
+}
+
+// Check the internal links of one section file and update them with the actual file name, label and tooltip
+// of the link target. Returns the number of broken links.
+func checkLinksOfOneFile(iSectionFile int) int {
+   sectionFile := BookStructure.SectionFiles[iSectionFile]
+   fmt.Printf("   %s\n", sectionFile.FileName)
+   nErrors := 0
+   for iElement, element := range sectionFile.Elements {
+      if element.StartTag == "<a" {
+         if element.ID == "" {
+            if element.Href != "" && !fileExists(element.NewText) {
+               // No ID defined, but internal link and Href target does not exist
+               fmt.Printf("      Internal link is wrong: <a href=\"%s\">%s<\\a>\n",
+                  element.Href, element.Text)
+               nErrors++
+            }
+
+         } else if book, id, crossBook := crossBookReference(element.ID); crossBook {
+            // Link to a bookmark of another book; check that target is defined in its inventory
+            bookMark, present := OtherBookmarks[book][id]
+            if !present {
+               fmt.Printf("      Link to other book not resolved (wrong id?): <a href=\"%s\">%s<\\a>\n",
+                  element.ID, element.Text)
+               nErrors++
+               continue
+            }
+            href := otherBookHref(sectionFile.FileName, book, bookMark.FileName, id)
+            linkText := referenceText(element, sectionFile.FileName)
+            if href != element.Href || (linkText != "" && linkText != element.Text) ||
+               !sameTooltip(bookMark.Tooltip, element.Tooltip) {
+
+               // Either URL or label (text) or tooltip (title) was changed
+               sectionFile.Elements[iElement].Modified = true
+               if linkText != "" {
+                  sectionFile.Elements[iElement].Text = linkText
+               }
+               sectionFile.Elements[iElement].NewText = href
+               sectionFile.Elements[iElement].Tooltip = bookMark.Tooltip
+               BookStructure.SectionFiles[iSectionFile].Modified = true
+               if bookMark.Tooltip == "" {
+                  fmt.Printf("      Link modified: <a href=\"%s\">%s<\\a>\n", href, sectionFile.Elements[iElement].Text)
+               } else {
+                  fmt.Printf("      Link modified: <a href=\"%s\" title=\"%s\">%s<\\a>\n",
+                     href, bookMark.Tooltip, sectionFile.Elements[iElement].Text)
+               }
+            }
+
+         } else {
+            // Link to an id renamed with option -fix-duplicate-ids
+            newID, renamed := RenamedIDs[element.NewText][element.ID]
+            if renamed {
+               fmt.Printf("      Link to renamed id: <a href=\"%s\"> -> id=\"%s\"\n", element.Href, newID)
+               element.ID = newID
+               sectionFile.Elements[iElement].ID = newID
+            }
+
+            // Internal link; check that target is defined
+            bookMark, present := Bookmarks[element.ID]
+            if !present {
+               if renamed {
+                  // Link to a renamed id that is not a bookmark: only the id is changed
+                  if element.NewText == sectionFile.FileName {
+                     sectionFile.Elements[iElement].NewText = ""
+                  }
+                  sectionFile.Elements[iElement].Modified = true
+                  BookStructure.SectionFiles[iSectionFile].Modified = true
+                  continue
+               }
+               if fileIDs(element.NewText)[element.ID] {
+                  // Link to an id that is not a bookmark (e.g. an id in the cover file)
+                  continue
+               }
+               fmt.Printf("      Internal link not resolved (wrong id?): <a href=\"%s\">%s<\\a>\n",
+                  element.Href, element.Text)
+               nErrors++
+            } else {
+               linkText := referenceText(element, sectionFile.FileName)
+               if renamed || bookMark.FileName != element.NewText ||
+                  (linkText != "" && linkText != element.Text) ||
+                  !sameTooltip(bookMark.Tooltip, element.Tooltip) {
+
+                  // Either file name or label (text) or tooltip (title) was changed
+                  sectionFile.Elements[iElement].Modified = true
+
+                  if linkText != "" {
+                     sectionFile.Elements[iElement].Text = linkText
+                  }
+                  if sectionFile.FileName == bookMark.FileName {
+                     sectionFile.Elements[iElement].NewText = ""
+                  } else {
+                     sectionFile.Elements[iElement].NewText = bookMark.FileName
+                  }
+                  sectionFile.Elements[iElement].Tooltip = bookMark.Tooltip
+                  BookStructure.SectionFiles[iSectionFile].Modified = true
+
+                  href := linkHref(sectionFile.FileName, sectionFile.Elements[iElement].NewText, element.ID)
+                  tooltip := sectionFile.Elements[iElement].Tooltip
+                  text := sectionFile.Elements[iElement].Text
+                  if tooltip == "" {
+                     fmt.Printf("      Link modified: <a href=\"%s\">%s<\\a>\n", href, text)
+                  } else {
+                     fmt.Printf("      Link modified: <a href=\"%s\" title=\"%s\">%s<\\a>\n",
+                        href, tooltip, text)
+                  }
+               }
+            }
+         }
+
+      } else if element.StartTag == groupedReferenceTag {
+         // Grouped reference: the links to the targets are newly generated (NewText is the start tag of the element)
+         content, nUnresolved := groupedReference(strings.Fields(element.ID), element.RefStyle, sectionFile.FileName)
+         if nUnresolved > 0 {
+            nErrors += nUnresolved
+            continue
          }
-      } else {
-         switch level {
-         case 1:
-            index = validSection1_Appendix.FindIndex(byteText)
-         case 2:
-            index = validSection2_Appendix.FindIndex(byteText)
-         case 3:
-            index = validSection3_Appendix.FindIndex(byteText)
-         case 4:
-            index = validSection4_Appendix.FindIndex(byteText)
+         newText := element.NewText + content + element.EndTag
+         sectionFile.Elements[iElement].NewText = newText
+         if newText != element.Text {
+            sectionFile.Elements[iElement].Modified = true
+            BookStructure.SectionFiles[iSectionFile].Modified = true
+            fmt.Printf("      Grouped reference modified: %s\n", content)
          }
-      }
 
-      if index == nil {
-         // no Section number was present
-         newText = secStr + text
-         fmt.Println("      Section number added:", newText)
-      } else {
-         // Section number was present: replace it with correct one
-         newText = secStr + string(byteText[index[1]:])
-         fmt.Println("      Section number updated:", newText)
+      } else if iFootnote := footnoteIndex(sectionFile, element); iFootnote >= 0 {
+         // Footnote: the links in the footnote text are updated (the text is part of the footnote list or of the margin note)
+         footnote := sectionFile.Footnotes[iFootnote]
+         text, nUnresolved := updateFragmentLinks(footnote.Text, sectionFile.FileName)
+         nErrors += nUnresolved
+         if text != footnote.Text {
+            footnote.Text = text
+            sectionFile.Footnotes[iFootnote] = footnote
+            sectionFile.Elements[iElement].NewText = footnoteReference(footnote)
+            sectionFile.Elements[iElement].Modified = sectionFile.Elements[iElement].NewText != element.Text
+            BookStructure.SectionFiles[iSectionFile].Modified = true
+            fmt.Printf("      Links in footnote %d modified: %s\n", footnote.Number, text)
+         }
       }
-      modified = true
    }
-   return
+   return nErrors
 }
 
-// Update text with correct caption number
-func updateCaptionText(text string, fig bool, nrCap int) (newText string, modified bool, label string) {
-   // If caption needs not to be numbered, return
-   if Counters.last_h1_type == "" {
-      newText = text
-      modified = false
-      label = text
-      return
+// Index of the footnote in sectionFile.Footnotes that is replaced by element (or -1, if element is no footnote)
+func footnoteIndex(sectionFile SectionFileType, element ElementType) int {
+   if !element.Replace {
+      return -1
    }
-
-   // Caption number needs to be numbered
-   var capStr string // Required caption number as string
-
-   // Determine required caption number
-   if Counters.last_h1_type == "Chapter" {
-      if fig {
-         capStr = fmt.Sprintf("Figure %d-%d: ", Counters.ih1_digit, nrCap)
-      } else {
-         capStr = fmt.Sprintf("Table %d-%d: ", Counters.ih1_digit, nrCap)
-      }
-   } else {
-      h1_letter := string(letters[Counters.ih1_letter-1])
-      if fig {
-         capStr = fmt.Sprintf("Figure %s-%d: ", h1_letter, nrCap)
-      } else {
-         capStr = fmt.Sprintf("Table %s-%d: ", h1_letter, nrCap)
+   for i, footnote := range sectionFile.Footnotes {
+      if element.ID == footnote.ID && element.NewText == footnoteReference(footnote) {
+         return i
       }
    }
-   label = capStr[0 : len(capStr)-2]
+   return -1
+}
 
-   // Has text the required caption number?
-   icap := minInt(len(capStr), len(text))
-   if text[0:icap] == capStr {
-      // text has the required caption number
-      newText = text
-      modified = false
+// Link <a href=..> in an html fragment of file fileName (e.g. in a footnote text) as link element. ID is the
+// target id (ID = "prefix:id" for a link to another book), or ID = "", if the link does not point to an id.
+func fragmentLink(fileName string, s *goquery.Selection) ElementType {
+   href := s.AttrOr("href", "")
+   link := ElementType{"<a", "</a>", s.Text(), href, "", s.AttrOr("title", ""), false, "", false, false,
+      s.AttrOr("data-ref", ""), s.AttrOr("data-ref-to", "")}
+   xref := s.AttrOr("data-xref", href)
+   if _, _, crossBook := crossBookReference(xref); crossBook {
+      link.ID = xref
+   } else if targetFileName, targetID, external := linkTarget(fileName, href); !external {
+      link.NewText, link.ID = targetFileName, targetID
+   }
+   return link
+}
 
-   } else {
-      // text has no or wrong caption number -> correct caption number
-      var index []int
-      byteText := []byte(text)
+// Links of an html fragment of file fileName that point to an id (see fragmentLink)
+func fragmentLinks(fragment string, fileName string) []ElementType {
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader("<div>" + fragment + "</div>"))
+   if err != nil {
+      log.Fatal(err)
+   }
+   links := make([]ElementType, 0, 5)
+   doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+      if link := fragmentLink(fileName, s); link.ID != "" {
+         links = append(links, link)
+      }
+   })
+   return links
+}
 
-      if Counters.last_h1_type == "Chapter" {
-         if fig {
-            index = validFigCaption.FindIndex(byteText)
-         } else {
-            index = validCaption.FindIndex(byteText)
+// Update the links in an html fragment of file fileName (e.g. in a footnote text) with the actual file names,
+// labels and tooltips of the link targets, as the links in the text of the file.
+// Returns the updated fragment and the number of links that are not resolved.
+func updateFragmentLinks(fragment string, fileName string) (string, int) {
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader("<div>" + fragment + "</div>"))
+   if err != nil {
+      log.Fatal(err)
+   }
+   nErrors := 0
+   doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+      link := fragmentLink(fileName, s)
+      if link.ID == "" {
+         return
+      }
+      book, otherID, crossBook := crossBookReference(link.ID)
+      if newID, renamed := RenamedIDs[link.NewText][link.ID]; renamed && !crossBook {
+         // Link to an id renamed with option -fix-duplicate-ids
+         link.ID = newID
+         s.SetAttr("href", linkHref(fileName, link.NewText, newID))
+      }
+      bookmark, present := lookupBookmark(link.ID)
+      if !present {
+         if !crossBook && fileIDs(link.NewText)[link.ID] {
+            // Link to an id that is not a bookmark (e.g. an id in the cover file)
+            return
          }
+         fmt.Printf("      Internal link not resolved (wrong id?): <a href=\"%s\">%s<\\a>\n", link.Href, link.Text)
+         nErrors++
+         return
+      }
+      if crossBook {
+         s.SetAttr("href", otherBookHref(fileName, book, bookmark.FileName, otherID))
+         s.SetAttr("data-xref", link.ID)
       } else {
-         if fig {
-            index = validFigCaption_Appendix.FindIndex(byteText)
-         } else {
-            index = validCaption_Appendix.FindIndex(byteText)
-         }
+         s.SetAttr("href", linkHref(fileName, bookmark.FileName, link.ID))
+      }
+      if text := referenceText(link, fileName); text != "" {
+         s.SetText(text)
       }
+      if bookmark.Tooltip != "" {
+         s.SetAttr("title", bookmark.Tooltip)
+      }
+   })
+   newFragment, _ := doc.Find("body > div").First().Html()
+   return newFragment, nErrors
+}
 
-      if index == nil {
-         // no caption number was present
-         newText = capStr + text
-         fmt.Println("      Caption number added:", newText)
-      } else {
-         // Caption number was present: replace it with correct one
-         newText = capStr + string(byteText[index[1]:])
-         fmt.Println("      Caption number updated:", newText)
+// = true, if fileName is a file of the book (section, cover or "table of contents" file) or exists in the book directory
+func fileExists(fileName string) bool {
+   for _, sectionFile := range BookStructure.SectionFiles {
+      if sectionFile.FileName == fileName {
+         return true
       }
-      modified = true
    }
-   return
+   if fileName == Configuration.CoverFileName || fileName == Configuration.TocFileName {
+      return true
+   }
+   fileInfo, err := os.Stat(filepath.FromSlash(fileName))
+   return err == nil && !fileInfo.IsDir()
 }
 
-// Update text with correct equation number
-func updateEquationText(text string) (newText string, modified bool, label string) {
-   // If section needs not to be numbered, return
-   if Counters.last_h1_type == "" {
-      newText = text
-      modified = false
-      label = ""
-      return
+// ids defined in an html file (e.g. the cover file); the result is cached
+func fileIDs(fileName string) map[string]bool {
+   ids, present := FileIDs[fileName]
+   if present {
+      return ids
    }
-
-   // Equation number needs to be numbered
-   var eqStr string // Required equation number as string
-
-   // Determine required equation number
-   if Counters.last_h1_type == "Chapter" {
-      eqStr = fmt.Sprintf("(%d.%d)", Counters.ih1_digit, Counters.iEquation)
-   } else {
-      h1_letter := string(letters[Counters.ih1_letter-1])
-      eqStr = fmt.Sprintf("(%s.%d)", h1_letter, Counters.iEquation)
+   ids = make(map[string]bool)
+   FileIDs[fileName] = ids
+   rawFile, err := ioutil.ReadFile(filepath.FromSlash(fileName))
+   if err != nil {
+      return ids
    }
-   label = eqStr
-
-   // Has text the required equation number?
-   byteText := []byte(text)
-   var index []int
-   if Counters.last_h1_type == "Chapter" {
-      index = validEquation.FindIndex(byteText)
-   } else {
-      index = validEquation_Appendix.FindIndex(byteText)
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(rawFile)))
+   if err != nil {
+      return ids
    }
+   doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+      ids[s.AttrOr("id", "")] = true
+   })
+   return ids
+}
 
-   if index == nil {
-      // No valid equation number present, add a new one
-      index = equationStart.FindIndex(byteText) // find "$$"
-      if index == nil {
-         fmt.Printf("Error: <div class=\"equation\" ...> present, but no \"$$\" to mark equation start\n")
-         os.Exit(1)
-      }
-      newText = text[0:index[1]] + " " + eqStr + ` \;\;\;\;\; ` + text[index[1]:]
-      fmt.Println("      Equation number added:", newText)
-      modified = true
-   } else {
-      // Check whether equation number is correct
-      iEnd := index[1]
-      index = withEquationNumber.FindIndex(byteText) // find "$$ ("
-      iBegin := index[1] - 1
-      if text[iBegin:iEnd] == eqStr {
-         // text has the required equation number
-         newText = text
-         modified = false
-      } else {
-         // text has no or wrong equation number -> correct equation number
-         newText = text[0:iBegin] + " " + eqStr + text[iEnd:]
-         fmt.Println("      Equation number updated:", newText)
-         modified = true
+// = true, if fileName is a section, cover, "table of contents" or solutions file of the book
+func isBookFile(fileName string) bool {
+   for _, file := range Configuration.SectionsFileNames {
+      if file == fileName {
+         return true
       }
    }
-   return
+   return fileName == Configuration.CoverFileName || fileName == Configuration.TocFileName ||
+      (fileName == Configuration.SolutionsFileName && fileName != "")
 }
 
-// Update section documents with changed section or caption numbers,
-// introducing missing element id's etc.
-func updateSectionDocuments() {
-   fmt.Printf("\nChange documents:\n")
-   for iSectionFile, sectionFile := range BookStructure.SectionFiles {
-      fmt.Printf("   %s\n", sectionFile.FileName)
-
-      // First, check all internal links
-      for iElement, element := range sectionFile.Elements {
-         if element.StartTag == "<a" {
-            if element.ID == "" {
-               if element.Href != "" {
-                  // No ID defined, but internal link. Check whether Href target exists
-                  fileExists := false
-                  for _, sectionFile2 := range BookStructure.SectionFiles {
-                     if sectionFile2.FileName == element.NewText {
-                        fileExists = true
-                        break
-                     }
-                  }
-                  if !fileExists {
-                     fmt.Printf("      Internal link is wrong: <a href=\"%s\">%s<\\a>\n",
-                        element.Href, element.Text)
-                  }
-               }
+// Target of the link href in file fileName. The target file name is relative to the book directory, e.g.
+// href = "../part2/chapter_03.html#sec_x" in file "part1/chapter_01.html" gives "part2/chapter_03.html" and "sec_x".
+// external = true, if href has a scheme or a host or is an absolute path (e.g. "http://..", "mailto:..", "/..").
+func linkTarget(fileName string, href string) (targetFileName string, targetID string, external bool) {
+   u, err := url.Parse(href)
+   if err != nil {
+      // Not a valid URL; use href literally
+      u = &url.URL{Path: href}
+      if i := strings.Index(href, "#"); i >= 0 {
+         u = &url.URL{Path: href[0:i], Fragment: href[i+1:]}
+      }
+   }
+   if u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+      return "", "", true
+   }
+   if u.Path == "" {
+      // "#id" (or "?query"): target in the same file
+      return fileName, u.Fragment, false
+   }
+   return path.Join(path.Dir(fileName), u.Path), u.Fragment, false
+}
 
-            } else {
-               // Internal link; check that target is defined
-               bookMark, present := Bookmarks[element.ID]
-               if !present {
-                  fmt.Printf("      Internal link not resolved (wrong id?): <a href=\"%s\">%s<\\a>\n",
-                     element.Href, element.Text)
-               } else {
-                  if bookMark.FileName != element.NewText ||
-                     (bookMark.Label != "" && bookMark.Label != element.Text) ||
-                     bookMark.Tooltip != element.Tooltip {
+// Reference to target from file fileName, where target is a file name relative to the book directory,
+// optionally followed by "#id", e.g. from "part1/chapter_01.html" to "part2/chapter_03.html#sec_x"
+// gives "../part2/chapter_03.html#sec_x".
+func relativeHref(fileName string, target string) string {
+   fragment := ""
+   if i := strings.Index(target, "#"); i >= 0 {
+      target, fragment = target[0:i], "#"+escapeFragment(target[i+1:])
+   }
+   relative, err := filepath.Rel(filepath.FromSlash(path.Dir(fileName)), filepath.FromSlash(target))
+   if err != nil {
+      return target + fragment
+   }
+   return (&url.URL{Path: filepath.ToSlash(relative)}).String() + fragment
+}
 
-                     // Either file name or label (text) or tooltip (title) was changed
-                     sectionFile.Elements[iElement].Modified = true
+// href of a link in file fileName to id in file targetFileName (targetFileName = "": same file)
+func linkHref(fileName string, targetFileName string, id string) string {
+   if targetFileName == "" || targetFileName == fileName {
+      return "#" + escapeFragment(id)
+   }
+   return relativeHref(fileName, targetFileName+"#"+id)
+}
 
-                     if bookMark.Label != "" {
-                        sectionFile.Elements[iElement].Text = bookMark.Label
-                     }
-                     if sectionFile.FileName == bookMark.FileName {
-                        sectionFile.Elements[iElement].NewText = ""
-                     } else {
-                        sectionFile.Elements[iElement].NewText = bookMark.FileName
-                     }
-                     sectionFile.Elements[iElement].Tooltip = bookMark.Tooltip
-                     sectionFile.Modified = true
-
-                     fileName := sectionFile.Elements[iElement].NewText
-                     tooltip := sectionFile.Elements[iElement].Tooltip
-                     text := sectionFile.Elements[iElement].Text
-                     if tooltip == "" {
-                        fmt.Printf("      Link modified: <a href=\"%s#%s\">%s<\\a>\n", fileName, element.ID, text)
-                     } else {
-                        fmt.Printf("      Link modified: <a href=\"%s#%s\" title=\"%s\">%s<\\a>\n",
-                           fileName, element.ID, tooltip, text)
-                     }
-                  }
-               }
-            }
-         }
+// id escaped as fragment of a URL: all characters other than A-Z, a-z, 0-9 and "-._~:" are percent-encoded
+// (e.g. "sec x" gives "sec%20x"), so the fragment can be used in an attribute without further escaping
+func escapeFragment(id string) string {
+   escaped := ""
+   for i := 0; i < len(id); i++ {
+      c := id[i]
+      if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~:", c) >= 0 {
+         escaped = escaped + string(c)
+      } else {
+         escaped = escaped + fmt.Sprintf("%%%02X", c)
       }
+   }
+   return escaped
+}
 
-      // If file has to be modified, modify it
-      if sectionFile.Modified || sectionFile.NewNav || sectionFile.UpdateNav {
-         // Section document needs to be modified; move the file to the backup directory
-         movedFileName := filepath.Join(BackupPath, sectionFile.FileName)
-         err := os.Rename(sectionFile.FileName, movedFileName)
-         if err != nil {
-            log.Fatal(err)
-         }
-
-         // Generate the file newly
-         updateOneSectionDocument(movedFileName, sectionFile, iSectionFile)
-      }
+// Name of fileName in the backup directory (the subdirectory of the file is generated, if needed)
+func backupFileName(fileName string) string {
+   movedFileName := filepath.Join(BackupPath, filepath.FromSlash(fileName))
+   err := os.MkdirAll(filepath.Dir(movedFileName), 0700)
+   if err != nil {
+      log.Fatal(err)
    }
+   return movedFileName
 }
 
 // Generate one section document newly
@@ -1145,6 +4082,11 @@ func updateOneSectionDocument(movedFileName string, sectionFile SectionFileType,
       log.Fatal(err)
    }
    old := string(oldFile)
+   if sectionFile.Source != "" {
+      // The file was modified before its structure was determined
+      old = sectionFile.Source
+   }
+   old = replaceRedirects(old, sectionFile.Redirects)
 
    // Initialize array indices
    iLast := 0   // Copy from this position in "old"
@@ -1201,10 +4143,32 @@ func updateOneSectionDocument(movedFileName string, sectionFile SectionFileType,
       }
    }
 
+   // Position of the footnote list (the list is newly generated, when reaching this position)
+   iBeginFootnotes, iEndFootnotes := footnoteListPosition(old)
+   if !footnoteListNeeded(sectionFile.Footnotes, iBeginFootnotes, iEndFootnotes) {
+      iBeginFootnotes = -1
+   }
+   writeFootnotes := func() {
+      fmt.Fprint(file, old[iLast:iBeginFootnotes])
+      fmt.Fprint(file, footnoteList(sectionFile.Footnotes))
+      if iBeginFootnotes == iEndFootnotes {
+         // New footnote list introduced before </body>
+         fmt.Fprint(file, "\n")
+      }
+      iLast = iEndFootnotes
+      iBeginFootnotes = -1
+   }
+
    // Loop over all modified elements
    for _, elem := range sectionFile.Elements {
       // Search next element in old document
       iNext = strings.Index(old[iSearch:], elem.StartTag)
+      if iNext >= 0 && iBeginFootnotes >= iLast && iSearch+iNext >= iBeginFootnotes {
+         // The footnote list is before the next element: generate the footnote list and continue search after the list
+         writeFootnotes()
+         iSearch = iLast
+         iNext = strings.Index(old[iSearch:], elem.StartTag)
+      }
       if iNext < 0 {
          fmt.Printf("Unknown error 1 (should not occur):\n"+
             "   Element \"%s ...>%s\" not found in file %s\n",
@@ -1212,13 +4176,36 @@ func updateOneSectionDocument(movedFileName string, sectionFile SectionFileType,
          os.Exit(1)
       }
 
-      if elem.Modified || elem.NewID {
+      if elem.Replace {
+         // The complete element is replaced (if modified); continue search after the element
+         iNext = iSearch + iNext
+         iTagEnd := strings.Index(old[iNext:], ">")
+         iEndTag := -1
+         if iTagEnd >= 0 {
+            iTagEnd = iNext + iTagEnd + 1
+            iEndTag = indexEndTag(old[iTagEnd:], elem.EndTag)
+         }
+         if iEndTag < 0 {
+            fmt.Printf("Unknown error 6 (should not occur):\n"+
+               "   Element \"%s ...>%s\" not found in file %s\n",
+               elem.StartTag, elem.Text, movedFileName)
+            os.Exit(1)
+         }
+         iEndTag = iTagEnd + iEndTag + len(elem.EndTag)
+         if elem.Modified {
+            fmt.Fprint(file, old[iLast:iNext])
+            fmt.Fprint(file, elem.NewText)
+            iLast = iEndTag
+         }
+         iSearch = iEndTag
+
+      } else if elem.Modified || elem.NewID {
          // Element text or id was modified; needs to be newly generated
 
          // Copy previous file content until beginning of this element
          iNext = iSearch + iNext
-         if elem.Modified && !elem.NewID {
-            // If only the text was modified (but not the ID), replace only the text
+         if elem.StartTag == "<a" {
+            // Next element is a link that needs to be modified
             iSearch = iNext
             iNext = strings.Index(old[iSearch:], ">")
             if iNext == -1 {
@@ -1228,22 +4215,19 @@ func updateOneSectionDocument(movedFileName string, sectionFile SectionFileType,
                os.Exit(1)
             }
             iNext = iSearch + iNext + 1
-
-            if elem.StartTag == "<a" {
-               // Next element is a link that needs to be modified
-               fmt.Fprint(file, old[iLast:iSearch])
-               if elem.Tooltip == "" {
-                  fmt.Fprint(file, "<a href=\""+elem.NewText+"#"+elem.ID+"\">"+elem.Text)
-               } else {
-                  fmt.Fprint(file, "<a href=\""+elem.NewText+"#"+elem.ID+"\" title=\""+elem.Tooltip+"\">"+elem.Text)
+            fmt.Fprint(file, old[iLast:iSearch])
+            startTag := old[iSearch:iNext]
+            href := linkHref(sectionFile.FileName, elem.NewText, elem.ID)
+            if _, _, crossBook := crossBookReference(elem.ID); crossBook {
+               // Link to another book: NewText is the URL and the reference is kept in attribute data-xref
+               href = elem.NewText
+               if !strings.Contains(startTag, "data-xref") {
+                  startTag = "<a data-xref=\"" + html.EscapeString(elem.ID) + "\"" + startTag[len("<a"):]
                }
-            } else {
-               // Next element is a section/caption element (like <h1>)
-               fmt.Fprint(file, old[iLast:iNext])
-               fmt.Fprint(file, elem.NewText)
             }
+            fmt.Fprint(file, linkStartTag(startTag, href, elem.Tooltip)+html.EscapeString(elem.Text))
             iSearch = iNext
-            iNext = strings.Index(old[iSearch:], elem.EndTag)
+            iNext = indexEndTag(old[iSearch:], elem.EndTag)
             if iNext == -1 {
                fmt.Printf("Unknown error 3 (should not occur):\n"+
                   "   Element \"%s ...>%s%s\" not found in file %s\n",
@@ -1254,19 +4238,38 @@ func updateOneSectionDocument(movedFileName string, sectionFile SectionFileType,
             iSearch = iLast + 1
 
          } else {
-            // No ID was present and it needs to be newly introduced
-            fmt.Fprint(file, old[iLast:iNext])
-            fmt.Fprintf(file, "%s id=\"%s\">%s%s", elem.StartTag, elem.ID, elem.NewText, elem.EndTag)
-            iSearch = iNext
-            iNext = strings.Index(old[iSearch:], elem.EndTag)
-            if iNext == -1 {
-               fmt.Printf("Unknown error 4 (should not occur):\n"+
-                  "   Element \"%s ...>%s%s\" not found in file %s\n",
-                  elem.StartTag, elem.Text, elem.EndTag, movedFileName)
+            // Next element is a section/caption element (like <h1>).
+            // If no ID was present, it needs to be newly introduced (other attributes are kept)
+            iAttributes := iNext + len(elem.StartTag)
+            iTagEnd := strings.Index(old[iAttributes:], ">")
+            if iTagEnd == -1 {
+               fmt.Printf("Unknown error 5 (should not occur):\n"+
+                  "   Element \"%s ...>%s\" not found in file %s\n",
+                  elem.StartTag, elem.Text, movedFileName)
                os.Exit(1)
             }
-            iLast = iSearch + iNext + len(elem.EndTag)
-            iSearch = iLast + 1
+            iTagEnd = iAttributes + iTagEnd + 1
+            fmt.Fprint(file, old[iLast:iAttributes])
+            if elem.NewID {
+               fmt.Fprintf(file, " id=\"%s\"", html.EscapeString(elem.ID))
+            }
+            iLast = iAttributes
+            iSearch = iTagEnd
+
+            // Replace the modified part of the text (elements nested in the remaining text are still updated)
+            if elem.NewText != elem.Text {
+               iBegin, iEnd, newPart := contentChange(old[iTagEnd:], elem.Text, elem.NewText, elem.EndTag)
+               if iEnd == -1 {
+                  fmt.Printf("Unknown error 4 (should not occur):\n"+
+                     "   Element \"%s ...>%s%s\" not found in file %s\n",
+                     elem.StartTag, elem.Text, elem.EndTag, movedFileName)
+                  os.Exit(1)
+               }
+               fmt.Fprint(file, old[iLast:iTagEnd+iBegin])
+               fmt.Fprint(file, newPart)
+               iLast = iTagEnd + iEnd
+               iSearch = iLast
+            }
          }
 
       } else {
@@ -1275,12 +4278,69 @@ func updateOneSectionDocument(movedFileName string, sectionFile SectionFileType,
       }
    }
 
+   // Generate footnote list, if not yet done
+   if iBeginFootnotes >= iLast {
+      writeFootnotes()
+   }
+
    // Copy last part of file
    if iLast <= len(old) {
       fmt.Fprint(file, old[iLast:])
    }
 }
 
+// Change of the text of an element, where old starts after the start tag of the element:
+// old[iBegin:iEnd] has to be replaced by newPart. If old agrees with text up to the modified
+// part, only this part is replaced (so that elements in the remaining text are kept).
+// Otherwise, the complete text is replaced (iEnd = -1, if endTag is not found).
+func contentChange(old string, text string, newText string, endTag string) (iBegin int, iEnd int, newPart string) {
+   // Length of common prefix and common suffix of text and newText
+   nMax := minInt(len(text), len(newText))
+   nPrefix := 0
+   for nPrefix < nMax && text[nPrefix] == newText[nPrefix] {
+      nPrefix++
+   }
+   nSuffix := 0
+   for nSuffix < nMax-nPrefix && text[len(text)-1-nSuffix] == newText[len(newText)-1-nSuffix] {
+      nSuffix++
+   }
+
+   iEnd = len(text) - nSuffix
+   if strings.HasPrefix(old, text[0:iEnd]) {
+      return nPrefix, iEnd, newText[nPrefix : len(newText)-nSuffix]
+   }
+   return 0, indexEndTag(old, endTag), newText
+}
+
+// Start tag of a link with the given href and title attribute (no title, if title = ""; title is plain text that is escaped).
+// The other attributes of startTag (e.g. <a class="x" href="..">) are kept.
+func linkStartTag(startTag string, href string, title string) string {
+   attributes := linkAttributes.ReplaceAllString(strings.TrimSuffix(startTag[len("<a"):], ">"), "")
+   if title == "" {
+      return "<a href=\"" + href + "\"" + attributes + ">"
+   }
+   return "<a href=\"" + href + "\" title=\"" + html.EscapeString(title) + "\"" + attributes + ">"
+}
+
+// Index of endTag (e.g. "</div>") in str, where str starts after the start tag of the element.
+// Nested elements with the same element name are skipped. Returns -1, if endTag is not found.
+func indexEndTag(str string, endTag string) int {
+   startTag := "<" + endTag[2:len(endTag)-1]
+   depth := 0
+   for i := 0; i < len(str); i++ {
+      if strings.HasPrefix(str[i:], endTag) {
+         if depth == 0 {
+            return i
+         }
+         depth--
+      } else if strings.HasPrefix(str[i:], startTag) && i+len(startTag) < len(str) &&
+         strings.ContainsRune(" \t\r\n>/", rune(str[i+len(startTag)])) {
+         depth++
+      }
+   }
+   return -1
+}
+
 // Write table of contents file
 func writeContentsFile(oldFileName string, fileName string) {
    file, err := os.Create(fileName)
@@ -1306,11 +4366,11 @@ func writeContentsFile(oldFileName string, fileName string) {
       str := string(oldFile)
       i := strings.Index(str, beginTableOfContents)
       if i >= 1 {
-         fmt.Fprint(file, str[0:i])
+         fmt.Fprint(file, replaceCounterLists(str[0:i]))
          writeContentsStructure(file)
          j := strings.Index(str[i:], endTableOfContents)
          if j >= 0 {
-            fmt.Fprint(file, str[i+j+len(endTableOfContents)+1:])
+            fmt.Fprint(file, replaceCounterLists(str[i+j+len(endTableOfContents)+1:]))
          } else {
             fmt.Printf("Constructing default tail of file since \"%s\" not found on file %s\n", endTableOfContents, oldFileName)
             writeContentsTail(file)
@@ -1325,6 +4385,31 @@ func writeContentsFile(oldFileName string, fileName string) {
    }
 }
 
+// Replace the text between <!-- BeginList Name --> and <!-- EndList Name --> by a list
+// of all elements of the counter Name defined in the configuration file
+func replaceCounterLists(str string) string {
+   for _, counter := range Configuration.Counters {
+      beginList := "<!-- BeginList " + counter.Name + " -->"
+      endList := "<!-- EndList " + counter.Name + " -->"
+      i := strings.Index(str, beginList)
+      if i < 0 {
+         continue
+      }
+      j := strings.Index(str[i:], endList)
+      if j < 0 {
+         fmt.Printf("List of \"%s\" not generated, since \"%s\" not found\n", counter.Name, endList)
+         continue
+      }
+      list := beginList + "\n<ul class=\"list-" + counter.Name + "\">\n"
+      for _, item := range CounterItems[counter.Name] {
+         list = list + fmt.Sprintf("<li><a href=\"%s\">%s</a></li>\n", relativeHref(Configuration.TocFileName, item.FileName+"#"+item.ID), item.Text)
+      }
+      list = list + "</ul>\n"
+      str = str[0:i] + list + str[i+j:]
+   }
+   return str
+}
+
 func writeContentsHead(file *os.File) {
    fmt.Fprintln(file, "<!DOCTYPE html>")
    fmt.Fprintln(file, "<html lang=\"en\">")
@@ -1354,14 +4439,35 @@ func shortenCaption(text string) string {
    }
 }
 
+// Sections and captions that shall be shown in the "table of contents"
+// (sections and captions with class="notoc" are removed)
+func tocSections(sections []SectionType) []SectionType {
+   visible := make([]SectionType, 0, len(sections))
+   for _, section := range sections {
+      if section.NoToc {
+         continue
+      }
+      captions := make([]CaptionType, 0, len(section.Captions))
+      for _, caption := range section.Captions {
+         if !caption.NoToc {
+            captions = append(captions, caption)
+         }
+      }
+      section.Captions = captions
+      section.Sections = tocSections(section.Sections)
+      visible = append(visible, section)
+   }
+   return visible
+}
+
 func writeContentsStructure(file *os.File) {
    fmt.Fprintln(file, beginTableOfContents)
    fmt.Fprintln(file, "<ol>")
-   fmt.Fprintf(file, "<li><a href=\"%s\"><strong>Book Cover</strong></a></li>\n\n", BookStructure.CoverFileName)
+   fmt.Fprintf(file, "<li><a href=\"%s\"><strong>Book Cover</strong></a></li>\n\n", relativeHref(BookStructure.TocFileName, BookStructure.CoverFileName))
 
-   for _, h1 := range BookStructure.Sections {
+   for _, h1 := range tocSections(BookStructure.Sections) {
       // h1 headings
-      fmt.Fprintf(file, "\n<li><a href=\"%s#%s\"><strong>%s</strong></a>", h1.FileName, h1.ID, h1.Text)
+      fmt.Fprintf(file, "\n<li><a href=\"%s\"><strong>%s</strong></a>", relativeHref(BookStructure.TocFileName, h1.FileName+"#"+h1.ID), h1.Text)
 
       if len(h1.Sections) == 0 && len(h1.Captions) == 0 {
          fmt.Fprintf(file, "</li>\n")
@@ -1370,7 +4476,7 @@ func writeContentsStructure(file *os.File) {
             // caption or figcaption
             fmt.Fprintf(file, "\n    <ul class=\"tree\">\n")
             for _, caption := range h1.Captions {
-               fmt.Fprintf(file, "    <li><a href=\"%s#%s\">%s</a></li>\n", caption.FileName, caption.ID, shortenCaption(caption.Text))
+               fmt.Fprintf(file, "    <li><a href=\"%s\">%s</a></li>\n", relativeHref(BookStructure.TocFileName, caption.FileName+"#"+caption.ID), shortenCaption(caption.Text))
             }
             fmt.Fprintln(file, "    </ul>")
          }
@@ -1382,7 +4488,7 @@ func writeContentsStructure(file *os.File) {
             fmt.Fprintf(file, "\n    <ol>\n")
 
             for _, h2 := range h1.Sections {
-               fmt.Fprintf(file, "    <li><a href=\"%s#%s\">%s</a>", h2.FileName, h2.ID, h2.Text)
+               fmt.Fprintf(file, "    <li><a href=\"%s\">%s</a>", relativeHref(BookStructure.TocFileName, h2.FileName+"#"+h2.ID), h2.Text)
 
                if len(h2.Sections) == 0 && len(h2.Captions) == 0 {
                   fmt.Fprintf(file, "</li>\n")
@@ -1391,7 +4497,7 @@ func writeContentsStructure(file *os.File) {
                      // caption or figcaption
                      fmt.Fprintf(file, "\n        <ul class=\"tree\">\n")
                      for _, caption := range h2.Captions {
-                        fmt.Fprintf(file, "        <li><a href=\"%s#%s\">%s</a></li>\n", caption.FileName, caption.ID, shortenCaption(caption.Text))
+                        fmt.Fprintf(file, "        <li><a href=\"%s\">%s</a></li>\n", relativeHref(BookStructure.TocFileName, caption.FileName+"#"+caption.ID), shortenCaption(caption.Text))
                      }
                      fmt.Fprintln(file, "        </ul>")
                   }
@@ -1402,7 +4508,7 @@ func writeContentsStructure(file *os.File) {
                      // h3 headings
                      fmt.Fprintf(file, "\n        <ul class=\"tree\">\n")
                      for _, h3 := range h2.Sections {
-                        fmt.Fprintf(file, "        <li><a href=\"%s#%s\">%s</a>", h3.FileName, h3.ID, h3.Text)
+                        fmt.Fprintf(file, "        <li><a href=\"%s\">%s</a>", relativeHref(BookStructure.TocFileName, h3.FileName+"#"+h3.ID), h3.Text)
 
                         if len(h3.Sections) == 0 && len(h3.Captions) == 0 {
                            fmt.Fprintf(file, "</li>\n")
@@ -1411,7 +4517,7 @@ func writeContentsStructure(file *os.File) {
                               // caption or figcaption
                               fmt.Fprintf(file, "\n            <ul class=\"tree\">\n")
                               for _, caption := range h3.Captions {
-                                 fmt.Fprintf(file, "            <li><a href=\"%s#%s\">%s</a></li>\n", caption.FileName, caption.ID, shortenCaption(caption.Text))
+                                 fmt.Fprintf(file, "            <li><a href=\"%s\">%s</a></li>\n", relativeHref(BookStructure.TocFileName, caption.FileName+"#"+caption.ID), shortenCaption(caption.Text))
                               }
                               fmt.Fprintln(file, "            </ul>")
                            }
@@ -1422,7 +4528,7 @@ func writeContentsStructure(file *os.File) {
                               // h4 headings
                               fmt.Fprintf(file, "\n            <ul class=\"tree\">\n")
                               for _, h4 := range h3.Sections {
-                                 fmt.Fprintf(file, "            <li><a href=\"%s#%s\">%s</a>", h4.FileName, h4.ID, h4.Text)
+                                 fmt.Fprintf(file, "            <li><a href=\"%s\">%s</a>", relativeHref(BookStructure.TocFileName, h4.FileName+"#"+h4.ID), h4.Text)
 
                                  if len(h4.Captions) == 0 {
                                     fmt.Fprintf(file, "</li>\n")
@@ -1430,7 +4536,7 @@ func writeContentsStructure(file *os.File) {
                                     // caption or figcaption
                                     fmt.Fprintf(file, "\n                <ul class=\"tree\">\n")
                                     for _, caption := range h4.Captions {
-                                       fmt.Fprintf(file, "                <li><a href=\"%s#%s\">%s</a></li>\n", caption.FileName, caption.ID, shortenCaption(caption.Text))
+                                       fmt.Fprintf(file, "                <li><a href=\"%s\">%s</a></li>\n", relativeHref(BookStructure.TocFileName, caption.FileName+"#"+caption.ID), shortenCaption(caption.Text))
                                     }
                                     fmt.Fprintln(file, "                </ul></li>")
                                  }
@@ -1453,22 +4559,24 @@ func writeContentsStructure(file *os.File) {
 
 // Write navigation bar
 func writeNavigationBar(file *os.File, iSection int) {
+   // The references in ReqNav are relative to the book directory
+   fileName := BookStructure.SectionFiles[iSection].FileName
    fmt.Fprintln(file, "<nav><ul>")
-   fmt.Fprintf(file, "  <li><a href=\"%s\">Table of Contents</a></li>\n", ReqNav[0])
-   fmt.Fprintf(file, "  <li><a href=\"%s\">Previous</a></li>\n", ReqNav[1])
+   fmt.Fprintf(file, "  <li><a href=\"%s\">Table of Contents</a></li>\n", relativeHref(fileName, ReqNav[0]))
+   fmt.Fprintf(file, "  <li><a href=\"%s\">Previous</a></li>\n", relativeHref(fileName, ReqNav[1]))
    if ReqNav[2] != "" {
-      fmt.Fprintf(file, "  <li><a href=\"%s\">Next</a></li>\n", ReqNav[2])
+      fmt.Fprintf(file, "  <li><a href=\"%s\">Next</a></li>\n", relativeHref(fileName, ReqNav[2]))
    }
-   fmt.Fprintf(file, "  <li><a href=\"%s\" class=\"start\">Cover</a></li>\n", ReqNav[3])
+   fmt.Fprintf(file, "  <li><a href=\"%s\" class=\"start\">Cover</a></li>\n", relativeHref(fileName, ReqNav[3]))
 
    H1Index_Actual := BookStructure.SectionFiles[iSection].H1Index
    H1Label := ""
    for i := 4; i < len(ReqNav); i++ {
       H1Label = BookStructure.Sections[i-4].Label
       if H1Index_Actual == i-4 {
-         fmt.Fprintf(file, "  <li><a href=\"%s\" class=\"actual\">%s</a></li>\n", ReqNav[i], H1Label)
+         fmt.Fprintf(file, "  <li><a href=\"%s\" class=\"actual\">%s</a></li>\n", relativeHref(fileName, ReqNav[i]), html.EscapeString(H1Label))
       } else {
-         fmt.Fprintf(file, "  <li><a href=\"%s\">%s</a></li>\n", ReqNav[i], H1Label)
+         fmt.Fprintf(file, "  <li><a href=\"%s\">%s</a></li>\n", relativeHref(fileName, ReqNav[i]), html.EscapeString(H1Label))
       }
    }
    fmt.Fprintln(file, "</ul></nav>")
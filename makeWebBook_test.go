@@ -0,0 +1,361 @@
+// Copyright 2015 DLR-SR. All rights reserved.
+// Use of this source code is governed by the
+// Creative Commons Attribution-NonCommercial-ShareAlike 4.0 International License
+// (http://creativecommons.org/licenses/by-nc-sa/4.0/).
+
+package main
+
+import (
+   "fmt"
+   "github.com/PuerkitoBio/goquery"
+   "io/ioutil"
+   "math/rand"
+   "os"
+   "strings"
+   "testing"
+)
+
+// Uncaptioned tables and images of source wrapped in caption/figure elements
+func wrapSource(t *testing.T, source string) string {
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader(source))
+   if err != nil {
+      t.Fatal(err)
+   }
+   tables, images := uncaptionedElements(doc)
+   wrapped, ok := wrapUncaptionedElements(source, tables, images)
+   if !ok {
+      t.Fatalf("wrapUncaptionedElements failed for %q", source)
+   }
+   return wrapped
+}
+
+func TestWrapUncaptionedElements(t *testing.T) {
+   figure := func(image string) string {
+      return "<figure>" + image + "<figcaption>" + missingCaption + "</figcaption></figure>"
+   }
+   tests := []struct {
+      source string
+      want   string
+   }{
+      {`<body><img src="a.png"></body>`, `<body>` + figure(`<img src="a.png">`) + `</body>`},
+      // Adjacent images: the end of the first image is the start of the second one
+      {`<body><img src="a.png"><img src="b.png"></body>`,
+         `<body>` + figure(`<img src="a.png">`) + figure(`<img src="b.png">`) + `</body>`},
+      {`<body><img src="a.png"><img src="b.png"><img src="c.png"></body>`,
+         `<body>` + figure(`<img src="a.png">`) + figure(`<img src="b.png">`) + figure(`<img src="c.png">`) + `</body>`},
+      {`<body><table><tr><td>1</td></tr></table><img src="a.png"></body>`,
+         `<body><table><caption>` + missingCaption + `</caption><tr><td>1</td></tr></table>` + figure(`<img src="a.png">`) + `</body>`},
+      {`<body><p>Text <img src="a.png"></p><table class="nonumber"></table></body>`,
+         `<body><p>Text <img src="a.png"></p><table class="nonumber"></table></body>`},
+   }
+   for _, test := range tests {
+      got := wrapSource(t, test.source)
+      if got != test.want {
+         t.Errorf("wrapUncaptionedElements(%q)\n   = %q\n want %q", test.source, got, test.want)
+      }
+      if strings.Count(got, "<figure>") != strings.Count(got, "</figure>") {
+         t.Errorf("wrapUncaptionedElements(%q) = %q is not balanced", test.source, got)
+      }
+   }
+}
+
+func TestExpandShorthandReferences(t *testing.T) {
+   DefinedIDs = map[string]bool{"sec_ops": true, "fig a": true}
+   tests := []struct {
+      source string
+      want   string
+   }{
+      {`<p>See [[sec_ops]].</p>`, `<p>See <a href="#sec_ops">sec_ops</a>.</p>`},
+      {`<p>See <ref to="sec_ops"/> and <ref to="fig a"></ref>.</p>`,
+         `<p>See <a href="#sec_ops">sec_ops</a> and <a href="#fig%20a">fig a</a>.</p>`},
+      // Not expanded: unknown ids, code, comments, TeX and attributes
+      {`<p>[[unknown]] <ref to="x&quot;y"/></p>`, `<p>[[unknown]] <ref to="x&quot;y"/></p>`},
+      {`<pre>x = [[sec_ops]]</pre><code>[[sec_ops]]</code>`, `<pre>x = [[sec_ops]]</pre><code>[[sec_ops]]</code>`},
+      {`<!-- [[sec_ops]] --><script>a[[sec_ops]]</script>`, `<!-- [[sec_ops]] --><script>a[[sec_ops]]</script>`},
+      {`<div class="equation"> $$ x = [[sec_ops]] $$ </div> \( [[sec_ops]] \)`,
+         `<div class="equation"> $$ x = [[sec_ops]] $$ </div> \( [[sec_ops]] \)`},
+      {`<img alt="[[sec_ops]]" src="a.png">`, `<img alt="[[sec_ops]]" src="a.png">`},
+   }
+   for _, test := range tests {
+      got, _ := expandShorthandReferences(test.source, "chapter_01.html")
+      if got != test.want {
+         t.Errorf("expandShorthandReferences(%q)\n   = %q\n want %q", test.source, got, test.want)
+      }
+   }
+}
+
+func TestReferencedTargets(t *testing.T) {
+   Targets = []TargetType{
+      {"fig_a", "chapter_01.html", "Figure", "Figure 1-1", nil, ""},
+      {"tab_a", "chapter_01.html", "Table", "Table 1-1", nil, ""},
+      {"eq_a", "chapter_01.html", "Equation", "Equation (1.1)", []string{"eq_row"}, ""},
+      {"fig_b1", "chapter_01.html", "Figure", "Figure 1-2a", nil, "Figure 1-2"},
+      {"fig_b2", "chapter_01.html", "Figure", "Figure 1-2b", nil, "Figure 1-2"},
+      {"fig_b", "chapter_01.html", "Figure", "Figure 1-2", nil, ""},
+      {"ref_a", "chapter_01.html", "Reference", "[1]", nil, ""},
+      {"fig_c", "chapter_01.html", "Figure", "Figure 1-3", nil, ""},
+      {"eq_s1", "chapter_01.html", "Equation", "Equation (1.2a)", []string{"eq_sub"}, "Equation (1.2)"},
+      {"eq_s2", "chapter_01.html", "Equation", "Equation (1.2b)", nil, "Equation (1.2)"},
+   }
+   index := targetIndices()
+   representative := representativeTargets()
+   if want := []int{0, 1, 2, 5, 5, 5, 6, 7, 8, 8}; fmt.Sprint(representative) != fmt.Sprint(want) {
+      t.Errorf("representativeTargets() = %v, want %v", representative, want)
+   }
+   tests := []struct {
+      id   string
+      to   string
+      want []int
+   }{
+      {"fig_a", "", []int{0}},
+      {"eq_row", "", []int{2}},
+      {"fig_a", "fig_c", []int{0, 5, 7}}, // Tables, equations and references in the range are not referenced
+      {"fig_c", "fig_a", []int{7}},
+      {"unknown", "fig_c", nil},
+      {"fig_b2", "", []int{5}}, // A subfigure references its parent figure
+      {"eq_s2", "", []int{8}},  // Subequations and their container are represented by the first subequation
+      {"eq_sub", "", []int{8}},
+   }
+   for _, test := range tests {
+      got := referencedTargets(index, representative, test.id, test.to)
+      if fmt.Sprint(got) != fmt.Sprint(test.want) {
+         t.Errorf("referencedTargets(%q, %q) = %v, want %v", test.id, test.to, got, test.want)
+      }
+   }
+}
+
+func TestCheckIDsEscapesRenamedIDs(t *testing.T) {
+   FixDuplicateIDs, NormalizeIDs = true, false
+   defer func() { FixDuplicateIDs = false }()
+   source := `<p id='a"b'>x</p><p id='a"b'>y</p><p id="c&lt;d">z</p><p id="c&lt;d">w</p>`
+   want := `<p id='a"b'>x</p><p id='a&#34;b_2'>y</p><p id="c&lt;d">z</p><p id="c&lt;d_2">w</p>`
+   if got := checkIDs("test.html", source); got != want {
+      t.Errorf("checkIDs(%q) =\n   %q, want\n   %q", source, got, want)
+   }
+}
+
+func TestFootnoteReferenceEscapesIDs(t *testing.T) {
+   defer func(style string) { Configuration.FootnoteStyle = style }(Configuration.FootnoteStyle)
+   footnote := FootnoteType{`a"b<c&d`, 3, "Text"}
+   for _, style := range []string{"", "sidenote"} {
+      Configuration.FootnoteStyle = style
+      reference := footnoteReference(footnote)
+      doc, err := goquery.NewDocumentFromReader(strings.NewReader("<p>" + reference + "</p>"))
+      if err != nil {
+         t.Fatal(err)
+      }
+      // The id is read back unchanged, and the reference is written again unchanged (not reported as modified)
+      s := doc.Find("p").Children().First()
+      if id := s.AttrOr("id", ""); id != "fnref_"+footnote.ID {
+         t.Errorf("style %q: id = %q, want %q", style, id, "fnref_"+footnote.ID)
+      }
+      if outer, _ := goquery.OuterHtml(s); outer != reference {
+         t.Errorf("style %q: reference = %q, parsed and rendered = %q", style, reference, outer)
+      }
+   }
+}
+
+func TestEquationAnchorsEscapeLabels(t *testing.T) {
+   defer func(counters CountersType) { Counters = counters }(Counters)
+   Counters.last_h1_type, Counters.ih1_digit, Counters.iEquation, Counters.subequations = "Chapter", 1, 0, nil
+   // Html text of <div class="equation"> with \label{a&b} and \label{c"d} (as rendered by goquery)
+   text := ` $$ \begin{align} a &amp;= b \label{a&amp;b} \\ c &amp;= d \label{c&#34;d} \end{align} $$ `
+   newText, _, _, rows := updateMultiLineEquationText(text)
+   if len(rows) != 2 || rows[0].ID != "a&b" || rows[1].ID != `c"d` {
+      t.Fatalf("rows = %+v, want the ids a&b and c\"d", rows)
+   }
+   newText = setEquationAnchors(newText, rows, "eq_a")
+   doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<div class="equation" id="eq_a">` + newText + `</div>`))
+   if err != nil {
+      t.Fatal(err)
+   }
+   var ids []string
+   doc.Find("span.equation-anchor").Each(func(i int, s *goquery.Selection) {
+      ids = append(ids, s.AttrOr("id", ""))
+   })
+   if fmt.Sprint(ids) != fmt.Sprint([]string{"a&b", `c"d`}) {
+      t.Errorf("anchor ids = %q, want [a&b c\"d]", ids)
+   }
+   // The anchors are written again unchanged (not reported as modified)
+   if inner, _ := doc.Find("div.equation").Html(); inner != newText {
+      t.Errorf("equation = %q, parsed and rendered = %q", newText, inner)
+   }
+}
+
+func TestOwnEquationTags(t *testing.T) {
+   for tex, want := range map[string]bool{
+      ` $$ a = b $$ `:                   false,
+      ` $$ a = b \tag{2.1} $$ `:         false, // generated tag
+      ` $$ a = b \tag{*} $$ `:           true,
+      ` $$ a = b \tag*{A} $$ `:          true,
+      ` $$ a = b \notag $$ `:            true,
+      ` $$ a = b \tag{iv.3} $$ `:        false,
+      ` $$ a = b \tag{2.1} \tag{*} $$ `: true,
+   } {
+      if got := ownEquationTag(tex); got != want {
+         t.Errorf("ownEquationTag(%q) = %v, want %v", tex, got, want)
+      }
+   }
+
+   // Rows of a multi-line equation with an own \tag are not numbered
+   defer func(counters CountersType) { Counters = counters }(Counters)
+   Counters.last_h1_type, Counters.ih1_digit, Counters.iEquation, Counters.subequations = "Chapter", 2, 0, nil
+   text := ` $$ \begin{align} a &amp;= b \tag{*} \\ c &amp;= d \tag{2.5} \end{align} $$ `
+   want := ` $$ \begin{align} a &amp;= b \tag{*} \\ c &amp;= d \tag{2.1} \end{align} $$ `
+   if newText, _, label, _ := updateMultiLineEquationText(text); newText != want || label != "(2.1)" {
+      t.Errorf("updateMultiLineEquationText(%q) = %q, %q, want %q, \"(2.1)\"", text, newText, label, want)
+   }
+}
+
+func TestUpdateFragmentLinks(t *testing.T) {
+   defer func(bookmarks map[string]BookmarkType) { Bookmarks = bookmarks }(Bookmarks)
+   Bookmarks = map[string]BookmarkType{
+      "fig_a": {"chapter_02.html", "Figure 2-1", "Figure 2-1: Overview", "Figure", "2-1"},
+      "sec_b": {"chapter_01.html", "Section 1.2", "1.2 Details", "Section", "1.2"},
+   }
+   fragment := `See <a href="chapter_02.html#fig_a">old</a>, <a href="#sec_b"><em>x</em></a> and <a href="#nothere">y</a>.`
+   want := `See <a href="chapter_02.html#fig_a" title="Figure 2-1: Overview">Figure 2-1</a>, ` +
+      `<a href="#sec_b" title="1.2 Details">Section 1.2</a> and <a href="#nothere">y</a>.`
+   got, nErrors := updateFragmentLinks(fragment, "chapter_01.html")
+   if got != want || nErrors != 1 {
+      t.Errorf("updateFragmentLinks(%q) =\n   %q, %d, want\n   %q, 1", fragment, got, nErrors, want)
+   }
+   if again, _ := updateFragmentLinks(got, "chapter_01.html"); again != got {
+      t.Errorf("updateFragmentLinks is not idempotent: %q", again)
+   }
+
+   var ids []string
+   for _, link := range fragmentLinks(got+` <a href="http://example.org">z</a>`, "chapter_01.html") {
+      ids = append(ids, link.ID)
+   }
+   if fmt.Sprint(ids) != "[fig_a sec_b nothere]" {
+      t.Errorf("fragmentLinks: ids = %v, want [fig_a sec_b nothere]", ids)
+   }
+}
+
+func TestSidenoteLinks(t *testing.T) {
+   defer func(structure BookStructureType, bookmarks map[string]BookmarkType, style string) {
+      BookStructure, Bookmarks, Configuration.FootnoteStyle = structure, bookmarks, style
+   }(BookStructure, Bookmarks, Configuration.FootnoteStyle)
+   Configuration.FootnoteStyle = "sidenote"
+   Bookmarks = map[string]BookmarkType{"fig_a": {"chapter_01.html", "Figure 1-1", "Figure 1-1: Overview", "Figure", "1-1"}}
+
+   // The margin note contains the footnote text: its links are updated in the reference
+   footnote := FootnoteType{"n1", 1, `See <a href="#fig_a">old</a>.`}
+   reference := footnoteReference(footnote)
+   BookStructure.SectionFiles = []SectionFileType{{FileName: "chapter_01.html", Footnotes: []FootnoteType{footnote},
+      Elements: []ElementType{{"<span class=\"sidenote-ref\"", "</span>", reference, "", reference, "", false, "n1", false, true, "", ""}}}}
+   if nErrors := checkLinksOfOneFile(0); nErrors != 0 {
+      t.Fatalf("checkLinksOfOneFile: %d errors, want 0", nErrors)
+   }
+   footnote.Text = `See <a href="#fig_a" title="Figure 1-1: Overview">Figure 1-1</a>.`
+   sectionFile := BookStructure.SectionFiles[0]
+   if sectionFile.Footnotes[0] != footnote || !sectionFile.Modified {
+      t.Errorf("footnote = %+v (file modified = %v), want %+v", sectionFile.Footnotes[0], sectionFile.Modified, footnote)
+   }
+   if element := sectionFile.Elements[0]; !element.Modified || element.NewText != footnoteReference(footnote) {
+      t.Errorf("margin note = %q (modified = %v), want %q", element.NewText, element.Modified, footnoteReference(footnote))
+   }
+}
+
+// Section file chapter_01.html with the given source processed as by makeWebBook (document structure,
+// links and update of the file in a temporary directory); returns the generated file
+func processSectionFile(t *testing.T, source string) string {
+   defer func(configuration ConfigurationType, structure BookStructureType, bookmarks map[string]BookmarkType,
+      targets []TargetType, counters CountersType) {
+      Configuration, BookStructure, Bookmarks, Targets, Counters = configuration, structure, bookmarks, targets, counters
+   }(Configuration, BookStructure, Bookmarks, Targets, Counters)
+   directory, err := os.Getwd()
+   if err != nil {
+      t.Fatal(err)
+   }
+   defer os.Chdir(directory)
+   if err = os.Chdir(t.TempDir()); err != nil {
+      t.Fatal(err)
+   }
+   if err = ioutil.WriteFile("chapter_01.html", []byte(source), 0644); err != nil {
+      t.Fatal(err)
+   }
+
+   Configuration = ConfigurationType{SectionsFileNames: []string{"chapter_01.html"}}
+   BookStructure = BookStructureType{SectionFiles: make([]SectionFileType, 0, 1), Sections: make([]SectionType, 0, 5)}
+   Bookmarks = make(map[string]BookmarkType)
+   Targets = make([]TargetType, 0, 5)
+   Counters = CountersType{}
+   H1Index_old := -1
+   getStructureOfOneFile("chapter_01.html", 0, rand.New(rand.NewSource(1)), &H1Index_old)
+   if nErrors := checkLinksOfOneFile(0); nErrors > 0 {
+      t.Fatalf("%d broken links in %q", nErrors, source)
+   }
+   sectionFile := BookStructure.SectionFiles[0]
+   sectionFile.NewNav, sectionFile.UpdateNav = false, false
+   if err = os.Rename("chapter_01.html", "chapter_01.old.html"); err != nil {
+      t.Fatal(err)
+   }
+   updateOneSectionDocument("chapter_01.old.html", sectionFile, 0)
+   generated, err := ioutil.ReadFile("chapter_01.html")
+   if err != nil {
+      t.Fatal(err)
+   }
+   return string(generated)
+}
+
+func TestLinkTextOfUnnumberedElements(t *testing.T) {
+   tests := []struct {
+      source string
+      link   string
+   }{
+      // Unnumbered h1 (e.g. a preface) with an entity
+      {`<h1 id="pre">Preface &amp; Notes</h1><p><a href="#pre">x</a></p>`,
+         `<a href="#pre" title="Preface &amp; Notes">Preface &amp; Notes</a>`},
+      // Heading with class="nonumber" with an entity and inline markup
+      {`<h1>Chapter 1 Intro</h1><h2 class="nonumber" id="sec_sum">Summary &amp; <em>Outlook</em></h2><p><a href="#sec_sum">x</a></p>`,
+         `<a href="#sec_sum" title="Summary &amp; Outlook">Summary &amp; Outlook</a>`},
+      // Caption with class="nonumber" with inline markup
+      {`<h1>Chapter 1 Intro</h1><table><caption class="nonumber" id="tab_a">Data <b>2&lt;3</b></caption><tr><td>1</td></tr></table><p><a href="#tab_a">x</a></p>`,
+         `<a href="#tab_a" title="Data 2&lt;3">Data 2&lt;3</a>`},
+   }
+   for _, test := range tests {
+      source := "<html><body>" + test.source + "</body></html>"
+      generated := processSectionFile(t, source)
+      if !strings.Contains(generated, test.link) {
+         t.Errorf("processed %q:\n   %q\n   does not contain %q", source, generated, test.link)
+         continue
+      }
+      // The link is not changed in a second run
+      if again := processSectionFile(t, generated); again != generated {
+         t.Errorf("second run of %q:\n   %q, want\n   %q", source, again, generated)
+      }
+   }
+}
+
+func TestGroupedReference(t *testing.T) {
+   defer func(structure BookStructureType, bookmarks map[string]BookmarkType, style string) {
+      BookStructure, Bookmarks, Configuration.ReferenceStyle = structure, bookmarks, style
+   }(BookStructure, Bookmarks, Configuration.ReferenceStyle)
+   BookStructure.SectionFiles = []SectionFileType{{FileName: "chapter_01.html"}}
+   Configuration.ReferenceStyle = "cleveref"
+   Bookmarks = map[string]BookmarkType{
+      "eq_a":  {"chapter_01.html", "(1.1)", "", "Equation", "(1.1)"},
+      "eq_b":  {"chapter_01.html", "(1.2)", "", "Equation", "(1.2)"},
+      "sec_a": {"chapter_01.html", "Section 1.2", "", "Section", "1.2"},
+      "fig_a": {"chapter_01.html", "Figure 1-1", "", "Figure", "1-1"},
+   }
+   link := func(id string, text string) string {
+      return `<a href="#` + id + `">` + text + `</a>`
+   }
+   tests := []struct {
+      ids  string
+      want string
+   }{
+      {"eq_b eq_a", "Equations " + link("eq_a", "(1.1)") + " and " + link("eq_b", "(1.2)")},
+      {"eq_a eq_b sec_a", "Equations " + link("eq_a", "(1.1)") + ", " + link("eq_b", "(1.2)") + " and Section " + link("sec_a", "1.2")},
+      {"fig_a eq_a sec_a", "Figure " + link("fig_a", "1-1") + ", Equation " + link("eq_a", "(1.1)") + " and Section " + link("sec_a", "1.2")},
+   }
+   for _, test := range tests {
+      content, nErrors := groupedReference(strings.Fields(test.ids), "", "chapter_01.html")
+      if content != test.want || nErrors != 0 {
+         t.Errorf("groupedReference(%q) =\n   %q, %d, want\n   %q, 0", test.ids, content, nErrors, test.want)
+      }
+   }
+}